@@ -0,0 +1,94 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrAccessDenied is used when a caller is not allowed to obtain a plugin
+var ErrAccessDenied = errors.New("plugin: access denied")
+
+type callerKey struct{}
+
+// WithCaller returns a context identifying the caller of plugin lookups
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFrom returns the caller set on the context with WithCaller
+func CallerFrom(ctx context.Context) (string, bool) {
+	caller, ok := ctx.Value(callerKey{}).(string)
+	return caller, ok
+}
+
+// AccessFunc decides whether the caller identified by the context may obtain
+// the plugin with the given registration, returning an error wrapping
+// ErrAccessDenied to deny access.
+type AccessFunc func(ctx context.Context, target *Registration) error
+
+// WithAccessControl restricts the plugins returned by InitContext lookups on
+// the set. Lookups made during initialization identify the caller by the
+// context caller, defaulting to the URI of the plugin being initialized.
+// Methods on the Set itself are not restricted.
+func WithAccessControl(fn AccessFunc) SetOpt {
+	return func(ps *Set) {
+		ps.access = fn
+	}
+}
+
+// RestrictAccess returns an AccessFunc only allowing the listed callers to
+// obtain plugins matching each rule, keyed by plugin type or URI. Plugins
+// not matching any rule are not restricted.
+func RestrictAccess(rules map[Type][]string) AccessFunc {
+	return func(ctx context.Context, target *Registration) error {
+		caller, _ := CallerFrom(ctx)
+		for t, allowed := range rules {
+			if !t.Matches(target) {
+				continue
+			}
+			permitted := false
+			for _, a := range allowed {
+				if a == caller {
+					permitted = true
+					break
+				}
+			}
+			if !permitted {
+				return fmt.Errorf("%q may not access %s: %w", caller, target.URI(), ErrAccessDenied)
+			}
+		}
+		return nil
+	}
+}
+
+// allowed checks whether the plugin may be obtained through the context
+func (i *InitContext) allowed(p *Plugin) error {
+	if i.plugins.access == nil {
+		return nil
+	}
+	ctx := i.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, ok := CallerFrom(ctx); !ok && i.registration != nil {
+		ctx = WithCaller(ctx, i.registration.URI())
+	}
+	return i.plugins.access(ctx, &p.Registration)
+}
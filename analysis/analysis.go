@@ -0,0 +1,250 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package analysis provides offline analysis of a plugin registry without
+// initializing any plugins. It backs command line tooling for inspecting the
+// plugins compiled into a daemon so the tooling and the daemon share the same
+// graph logic.
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/containerd/plugin"
+)
+
+// Severity of a lint finding
+type Severity string
+
+const (
+	// SeverityError is a finding which prevents the registry from loading
+	SeverityError Severity = "error"
+	// SeverityWarning is a finding which likely results in runtime failures
+	SeverityWarning Severity = "warning"
+)
+
+// Finding is an issue found in a registry
+type Finding struct {
+	Severity Severity
+	// Plugin is the URI of the plugin the finding applies to, if any
+	Plugin  string
+	Message string
+}
+
+func (f Finding) String() string {
+	if f.Plugin == "" {
+		return fmt.Sprintf("%s: %s", f.Severity, f.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", f.Severity, f.Plugin, f.Message)
+}
+
+// Report is the result of analyzing a registry
+type Report struct {
+	// Order is the initialization order of the enabled plugins
+	Order []string
+	// Disabled is the list of plugins removed by the filter
+	Disabled []string
+	// Findings are the lint findings for the registry
+	Findings []Finding
+	// Schemas are the configuration schemas keyed by plugin URI
+	Schemas map[string]*plugin.Schema
+}
+
+// Analyze computes the order, lint findings and config schemas of a registry.
+// An error is returned if the graph cannot be computed with the filter, such
+// as when enabled plugins conflict or depend on each other in a cycle.
+func Analyze(registry plugin.Registry, filter plugin.DisableFilter) (Report, error) {
+	report := Report{
+		Findings: Lint(registry),
+		Schemas:  registry.ConfigSchemas(),
+	}
+	g, err := resolve(registry, filter)
+	if err != nil {
+		return report, err
+	}
+	for _, r := range g.Ordered {
+		report.Order = append(report.Order, r.URI())
+	}
+	for _, r := range g.Disabled {
+		report.Disabled = append(report.Disabled, r.URI())
+	}
+	return report, nil
+}
+
+// Lint checks a registry for problems which would cause plugins to fail to
+// load or to be ordered incorrectly.
+func Lint(registry plugin.Registry) []Finding {
+	var findings []Finding
+	provided := map[string]bool{}
+	for _, r := range registry {
+		for _, c := range r.Provides {
+			provided[c] = true
+		}
+	}
+	for _, r := range registry {
+		if r.InitFn == nil {
+			findings = append(findings, Finding{SeverityError, r.URI(), "no init function"})
+		}
+		for _, field := range []struct {
+			name  string
+			types []plugin.Type
+		}{
+			{"requires", r.Requires},
+			{"barrier", r.Barrier},
+			{"before", r.Before},
+		} {
+			for _, t := range field.types {
+				if t != "*" && !registered(registry, t) {
+					findings = append(findings, Finding{SeverityWarning, r.URI(), fmt.Sprintf("%s type %s has no registered plugins", field.name, t)})
+				}
+				for _, a := range registry {
+					if a.HasAlias(string(t)) {
+						findings = append(findings, Finding{SeverityWarning, r.URI(), fmt.Sprintf("%s %s is a deprecated alias of %s", field.name, t, a.URI())})
+					}
+				}
+			}
+		}
+		for _, need := range r.Needs {
+			if !provided[need] {
+				findings = append(findings, Finding{SeverityWarning, r.URI(), fmt.Sprintf("needs %s which no registered plugin provides", need)})
+			}
+		}
+	}
+	if err := registry.Validate(func(*plugin.Registration) bool { return false }); err != nil {
+		findings = append(findings, Finding{Severity: SeverityError, Message: err.Error()})
+	}
+	return findings
+}
+
+// dotStyles are the Graphviz edge styles of the edge kinds, edges of other
+// kinds are drawn dotted
+var dotStyles = map[plugin.EdgeKind]string{
+	plugin.EdgeRequires: "",
+	plugin.EdgeNeeds:    "",
+	plugin.EdgeOptional: "bold",
+	plugin.EdgeBarrier:  "dashed",
+}
+
+// WriteDot writes the dependency graph of the enabled plugins in the
+// Graphviz dot format, using the edges of Registry.DependencyGraph.
+// Requires and needed capabilities are drawn as solid edges, optional
+// dependencies as bold edges, barriers as dashed edges and ordering
+// constraints, such as before and config provider constraints, as dotted
+// edges.
+func WriteDot(w io.Writer, registry plugin.Registry, filter plugin.DisableFilter) error {
+	if err := registry.Validate(filter); err != nil {
+		return err
+	}
+	g := registry.DependencyGraph(filter)
+	var b strings.Builder
+	b.WriteString("digraph plugins {\n")
+	for _, r := range g.Nodes {
+		fmt.Fprintf(&b, "\t%q;\n", r.URI())
+	}
+	type line struct {
+		from, to, style string
+	}
+	seen := map[line]bool{}
+	for _, e := range g.Edges {
+		style, ok := dotStyles[e.Kind]
+		if !ok {
+			style = "dotted"
+		}
+		l := line{e.Plugin, e.Dependency, style}
+		if seen[l] {
+			continue
+		}
+		seen[l] = true
+		if style == "" {
+			fmt.Fprintf(&b, "\t%q -> %q;\n", l.from, l.to)
+		} else {
+			fmt.Fprintf(&b, "\t%q -> %q [style=%s];\n", l.from, l.to, style)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteDocs writes markdown documentation of every plugin in the registry
+// including its dependencies and configuration options.
+func WriteDocs(w io.Writer, registry plugin.Registry) error {
+	var b strings.Builder
+	sorted := append(plugin.Registry(nil), registry...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].URI() < sorted[j].URI()
+	})
+	for _, r := range sorted {
+		fmt.Fprintf(&b, "## %s\n\n", r.URI())
+		if len(r.Requires) > 0 {
+			fmt.Fprintf(&b, "Requires: %s\n\n", joinTypes(r.Requires))
+		}
+		if len(r.Optional) > 0 {
+			fmt.Fprintf(&b, "Optional: %s\n\n", joinTypes(r.Optional))
+		}
+		s := r.ConfigSchema()
+		if s == nil || len(s.Properties) == 0 {
+			continue
+		}
+		b.WriteString("| Option | Type | Default | Description |\n")
+		b.WriteString("|--------|------|---------|-------------|\n")
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p := s.Properties[name]
+			def := ""
+			if p.Default != nil {
+				def = fmt.Sprintf("`%v`", p.Default)
+			}
+			fmt.Fprintf(&b, "| `%s` | %s | %s | %s |\n", name, p.Type, def, p.Description)
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// resolve computes the registration graph, returning an error if the graph
+// cannot be computed with the filter
+func resolve(registry plugin.Registry, filter plugin.DisableFilter) (*plugin.RegistrationGraph, error) {
+	if err := registry.Validate(filter); err != nil {
+		return nil, err
+	}
+	return registry.Resolve(filter), nil
+}
+
+func registered(registry plugin.Registry, t plugin.Type) bool {
+	for _, r := range registry {
+		if t.Matches(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinTypes(types []plugin.Type) string {
+	s := make([]string, len(types))
+	for i, t := range types {
+		s[i] = "`" + t.String() + "`"
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,141 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package analysis
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/containerd/plugin"
+)
+
+type boltConfig struct {
+	Path string `toml:"path" description:"Database path" default:"meta.db"`
+}
+
+func testInit(*plugin.InitContext) (interface{}, error) {
+	return nil, nil
+}
+
+func testRegistry() plugin.Registry {
+	var registry plugin.Registry
+	return registry.Register(&plugin.Registration{
+		Type:   "content",
+		ID:     "local",
+		InitFn: testInit,
+	}).Register(&plugin.Registration{
+		Type:     "metadata",
+		ID:       "bolt",
+		Requires: []plugin.Type{"content", "snapshotter"},
+		Config:   &boltConfig{Path: "meta.db"},
+		InitFn:   testInit,
+	}).Register(&plugin.Registration{
+		Type:    "warmup",
+		ID:      "images",
+		Barrier: []plugin.Type{"metadata"},
+	})
+}
+
+func TestAnalyze(t *testing.T) {
+	report, err := Analyze(testRegistry(), func(r *plugin.Registration) bool {
+		return r.Type == "warmup"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(report.Order, ",") != "content.local,metadata.bolt" {
+		t.Fatalf("unexpected order %v", report.Order)
+	}
+	if strings.Join(report.Disabled, ",") != "warmup.images" {
+		t.Fatalf("unexpected disabled %v", report.Disabled)
+	}
+	if _, ok := report.Schemas["metadata.bolt"]; !ok {
+		t.Fatal("expected metadata schema")
+	}
+	var findings []string
+	for _, f := range report.Findings {
+		findings = append(findings, f.String())
+	}
+	expected := []string{
+		"warning: metadata.bolt: requires type snapshotter has no registered plugins",
+		"error: warmup.images: no init function",
+	}
+	if strings.Join(findings, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected findings %v", findings)
+	}
+}
+
+func TestLintCircularDependency(t *testing.T) {
+	var registry plugin.Registry
+	registry = registry.Register(&plugin.Registration{
+		Type: "a", ID: "a", Requires: []plugin.Type{"b"}, InitFn: testInit,
+	}).Register(&plugin.Registration{
+		Type: "b", ID: "b", Requires: []plugin.Type{"a"}, InitFn: testInit,
+	})
+	findings := Lint(registry)
+	if len(findings) != 1 || findings[0].Severity != SeverityError || !strings.Contains(findings[0].Message, "circular") {
+		t.Fatalf("unexpected findings %v", findings)
+	}
+
+	report, err := Analyze(registry, func(*plugin.Registration) bool { return false })
+	if !errors.Is(err, plugin.ErrPluginCircularDependency) {
+		t.Fatalf("expected circular dependency error, got %v", err)
+	}
+	if len(report.Findings) != 1 || report.Order != nil {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if _, err := Analyze(registry, func(r *plugin.Registration) bool { return r.ID == "a" }); err != nil {
+		t.Fatalf("expected graph without the cycle to resolve, got %v", err)
+	}
+}
+
+func TestWriteDotAndDocs(t *testing.T) {
+	var dot strings.Builder
+	registry := testRegistry().Register(&plugin.Registration{
+		Type:   "tracing",
+		ID:     "otel",
+		Before: []plugin.Type{"content"},
+		InitFn: testInit,
+	})
+	if err := WriteDot(&dot, registry, func(*plugin.Registration) bool { return false }); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`"metadata.bolt" -> "content.local";`,
+		`"warmup.images" -> "metadata.bolt" [style=dashed];`,
+		`"content.local" -> "tracing.otel" [style=dotted];`,
+	} {
+		if !strings.Contains(dot.String(), expected) {
+			t.Errorf("expected dot output to contain %q:\n%s", expected, dot.String())
+		}
+	}
+
+	var docs strings.Builder
+	if err := WriteDocs(&docs, testRegistry()); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"## metadata.bolt",
+		"Requires: `content`, `snapshotter`",
+		"| `path` | string | `meta.db` | Database path |",
+	} {
+		if !strings.Contains(docs.String(), expected) {
+			t.Errorf("expected docs to contain %q:\n%s", expected, docs.String())
+		}
+	}
+}
@@ -0,0 +1,130 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Builder builds a Registration, validating each field as it is set. The
+// first error is kept and returned when the registration is built.
+type Builder struct {
+	r   Registration
+	err error
+}
+
+// Build returns a builder for the plugin with the given type and ID
+func Build(t Type, id string) *Builder {
+	b := &Builder{r: Registration{Type: t, ID: id}}
+	if t == "" {
+		b.fail(ErrNoType)
+	} else if id == "" {
+		b.fail(ErrNoPluginID)
+	}
+	return b
+}
+
+func (b *Builder) fail(err error) *Builder {
+	if b.err == nil {
+		b.err = fmt.Errorf("%s: %w", b.r.URI(), err)
+	}
+	return b
+}
+
+// Requires adds plugin types or URIs the plugin requires
+func (b *Builder) Requires(types ...Type) *Builder {
+	b.r.Requires = append(b.r.Requires, types...)
+	for _, t := range types {
+		if t == "*" && len(b.r.Requires) != 1 {
+			return b.fail(ErrInvalidRequires)
+		}
+		if _, _, err := t.constraint(); err != nil {
+			return b.fail(err)
+		}
+	}
+	return b
+}
+
+// Optional adds plugin types or URIs initialized before the plugin when
+// they are present
+func (b *Builder) Optional(types ...Type) *Builder {
+	b.r.Optional = append(b.r.Optional, types...)
+	for _, t := range types {
+		if _, _, err := t.constraint(); (t == "*" && len(b.r.Optional) != 1) || err != nil {
+			return b.fail(ErrInvalidOptional)
+		}
+	}
+	return b
+}
+
+// Config sets the default configuration of the plugin
+func (b *Builder) Config(config interface{}) *Builder {
+	if config == nil {
+		return b.fail(errors.New("nil config"))
+	}
+	b.r.Config = config
+	return b
+}
+
+// Init sets the function initializing the plugin
+func (b *Builder) Init(fn func(*InitContext) (interface{}, error)) *Builder {
+	if fn == nil {
+		return b.fail(errors.New("nil init function"))
+	}
+	b.r.InitFn = fn
+	return b
+}
+
+// With applies registration options for fields without a builder method
+func (b *Builder) With(opts ...RegistrationOpt) *Builder {
+	for _, o := range opts {
+		o(&b.r)
+	}
+	return b
+}
+
+// Registration returns the built registration or the first error
+func (b *Builder) Registration() (*Registration, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.r.InitFn == nil {
+		return nil, fmt.Errorf("%s: no init function", b.r.URI())
+	}
+	r := b.r
+	var validated Registry
+	if err := update(&validated, func(registry Registry) Registry {
+		validate(&r)
+		return registry
+	}); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Register adds the built registration to the registry, returning an error
+// rather than panicking if it is invalid or already registered.
+func (b *Builder) Register(registry *Registry) error {
+	r, err := b.Registration()
+	if err != nil {
+		return err
+	}
+	return update(registry, func(registry Registry) Registry {
+		return registry.Register(r)
+	})
+}
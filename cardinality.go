@@ -0,0 +1,92 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCardinality is used when the number of enabled plugins matching a
+// Requires entry is outside of its cardinality.
+var ErrCardinality = errors.New("plugin: cardinality not satisfied")
+
+// Cardinality bounds the number of enabled plugins matching a Requires entry
+type Cardinality struct {
+	// Min is the minimum number of matching plugins
+	Min int
+	// Max is the maximum number of matching plugins, 0 is unbounded
+	Max int
+}
+
+// AtLeast returns a cardinality of at least n plugins
+func AtLeast(n int) Cardinality {
+	return Cardinality{Min: n}
+}
+
+// Exactly returns a cardinality of exactly n plugins
+func Exactly(n int) Cardinality {
+	return Cardinality{Min: n, Max: n}
+}
+
+func (c Cardinality) String() string {
+	switch {
+	case c.Max == 0:
+		return fmt.Sprintf("at least %d", c.Min)
+	case c.Min == c.Max:
+		return fmt.Sprintf("exactly %d", c.Min)
+	}
+	return fmt.Sprintf("between %d and %d", c.Min, c.Max)
+}
+
+func (c Cardinality) valid() bool {
+	return c.Min >= 0 && c.Max >= 0 && (c.Max == 0 || c.Max >= c.Min) && (c.Min > 0 || c.Max > 0)
+}
+
+func (c Cardinality) satisfiedBy(n int) bool {
+	return n >= c.Min && (c.Max == 0 || n <= c.Max)
+}
+
+// CardinalityError is returned when the number of enabled plugins matching
+// a Requires entry does not satisfy its cardinality.
+type CardinalityError struct {
+	// Plugin is the URI of the plugin declaring the cardinality
+	Plugin string
+	// Requires is the entry the cardinality applies to
+	Requires Type
+	Cardinality
+	// Count is the number of enabled plugins matching the entry
+	Count int
+}
+
+func (e *CardinalityError) Error() string {
+	return fmt.Sprintf("%s requires %s %s plugins, found %d: %v", e.Plugin, e.Cardinality, e.Requires, e.Count, ErrCardinality)
+}
+
+// Unwrap returns ErrCardinality
+func (e *CardinalityError) Unwrap() error {
+	return ErrCardinality
+}
+
+func containsType(types []Type, t Type) bool {
+	for _, v := range types {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
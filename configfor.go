@@ -0,0 +1,89 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ProvideConfig supplies a configuration fragment for the plugin with the
+// given URI, such as defaults detected from the host. The plugin being
+// initialized must declare the target in ConfigFor, which ensures the
+// fragment is provided before the target is initialized. Fragments are
+// merged into the target's configuration with Set.MergeConfig.
+func (i *InitContext) ProvideConfig(target string, fragment map[string]interface{}) error {
+	if i.registration == nil || i.plugins == nil {
+		return fmt.Errorf("config for %s provided outside of plugin init: %w", target, ErrInvalidConfigFor)
+	}
+	var r Registration
+	if j := strings.LastIndexByte(target, '.'); j > 0 {
+		r.Type, r.ID = Type(target[:j]), target[j+1:]
+	}
+	declared := false
+	for _, t := range i.registration.ConfigFor {
+		if t.Matches(&r) {
+			declared = true
+			break
+		}
+	}
+	if !declared {
+		return fmt.Errorf("%s does not declare providing config to %s: %w", i.registration.URI(), target, ErrInvalidConfigFor)
+	}
+	ps := i.plugins
+	ps.cfgMu.Lock()
+	defer ps.cfgMu.Unlock()
+	if ps.fragments == nil {
+		ps.fragments = map[string][]map[string]interface{}{}
+	}
+	ps.fragments[target] = append(ps.fragments[target], fragment)
+	return nil
+}
+
+// MergeConfig merges the configuration fragments provided for the plugin
+// with the given URI into its raw configuration before it is decoded.
+// Values in the raw configuration take precedence over provided values,
+// earlier fragments take precedence over later ones. The merged
+// configuration is returned, the raw configuration is not modified.
+func (ps *Set) MergeConfig(uri string, raw map[string]interface{}) map[string]interface{} {
+	ps.cfgMu.Lock()
+	fragments := ps.fragments[uri]
+	ps.cfgMu.Unlock()
+
+	merged := copyConfig(raw)
+	for _, f := range fragments {
+		mergeDefaults(merged, f)
+	}
+	return merged
+}
+
+// mergeDefaults sets the values from defaults which are not set in dst,
+// merging nested tables.
+func mergeDefaults(dst, defaults map[string]interface{}) {
+	for k, v := range defaults {
+		existing, ok := dst[k]
+		if !ok {
+			dst[k] = copyConfigValue(v)
+			continue
+		}
+		em, ok1 := existing.(map[string]interface{})
+		dm, ok2 := v.(map[string]interface{})
+		if ok1 && ok2 {
+			mergeDefaults(em, dm)
+		}
+	}
+}
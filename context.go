@@ -19,6 +19,11 @@ package plugin
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
 )
@@ -30,25 +35,137 @@ type InitContext struct {
 	Config            interface{}
 	RegisterReadiness func() func()
 
+	// Logger is the logger plugins should use, defaults to discarding output.
+	// During Init, the logger adds the "type" and "id" of the plugin to
+	// every message.
+	Logger Logger
+	// TracerProvider is the tracer provider plugins should use for tracing,
+	// typically a go.opentelemetry.io/otel/trace.TracerProvider.
+	TracerProvider interface{}
+	// MeterProvider is the meter provider plugins should use for metrics,
+	// typically a go.opentelemetry.io/otel/metric.MeterProvider.
+	MeterProvider interface{}
+
+	// ConfigProvided is set when a configuration section was found for the
+	// plugin, as opposed to Config only holding the registered defaults.
+	ConfigProvided bool
+
 	// Meta is metadata plugins can fill in at init
 	Meta *Meta
 
-	plugins *Set
+	plugins      *Set
+	graph        *RegistrationGraph
+	registration *Registration
+	profileDir   string
+	timeout      time.Duration
+	trace        *InitTrace
+	readiness    map[string]ReadinessSource
+}
+
+// Logger is the structured logging interface provided to plugins. The
+// arguments after the message are alternating keys and values, matching
+// the methods of log/slog.Logger.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// scopedLogger adds the identity of a plugin to the messages of a logger
+type scopedLogger struct {
+	base Logger
+	args []interface{}
+}
+
+// scopeLogger returns a logger adding the type and ID of the registration,
+// loggers already scoped to a plugin are returned as is
+func scopeLogger(l Logger, r *Registration) Logger {
+	if _, ok := l.(*scopedLogger); ok {
+		return l
+	}
+	return &scopedLogger{
+		base: l,
+		args: []interface{}{"type", r.Type, "id", r.ID},
+	}
+}
+
+func (l *scopedLogger) with(args []interface{}) []interface{} {
+	return append(l.args[:len(l.args):len(l.args)], args...)
+}
+
+func (l *scopedLogger) Debug(msg string, args ...interface{}) { l.base.Debug(msg, l.with(args)...) }
+func (l *scopedLogger) Info(msg string, args ...interface{})  { l.base.Info(msg, l.with(args)...) }
+func (l *scopedLogger) Warn(msg string, args ...interface{})  { l.base.Warn(msg, l.with(args)...) }
+func (l *scopedLogger) Error(msg string, args ...interface{}) { l.base.Error(msg, l.with(args)...) }
+
+// InitContextOpt is an option used when creating an InitContext
+type InitContextOpt func(*InitContext)
+
+// WithLogger sets the base logger available to the plugin, such as a
+// log/slog.Logger, which is scoped to the plugin during Init
+func WithLogger(l Logger) InitContextOpt {
+	return func(ic *InitContext) {
+		ic.Logger = l
+	}
+}
+
+// WithTracerProvider sets the tracer provider available to the plugin
+func WithTracerProvider(tp interface{}) InitContextOpt {
+	return func(ic *InitContext) {
+		ic.TracerProvider = tp
+	}
+}
+
+// WithMeterProvider sets the meter provider available to the plugin
+func WithMeterProvider(mp interface{}) InitContextOpt {
+	return func(ic *InitContext) {
+		ic.MeterProvider = mp
+	}
+}
+
+// WithRegistrationGraph sets the registration graph the plugin is being
+// initialized from. The graph is shared between plugins and must not be
+// modified.
+func WithRegistrationGraph(g *RegistrationGraph) InitContextOpt {
+	return func(ic *InitContext) {
+		ic.graph = g
+	}
+}
+
+// WithInitTimeout sets the time the plugin init function may run when the
+// registration does not set a Timeout
+func WithInitTimeout(d time.Duration) InitContextOpt {
+	return func(ic *InitContext) {
+		ic.timeout = d
+	}
 }
 
 // NewContext returns a new plugin InitContext
-func NewContext(ctx context.Context, plugins *Set, properties map[string]string) *InitContext {
+func NewContext(ctx context.Context, plugins *Set, properties map[string]string, opts ...InitContextOpt) *InitContext {
 	if properties == nil {
 		properties = map[string]string{}
 	}
-	return &InitContext{
+	ic := &InitContext{
 		Context:    ctx,
 		Properties: properties,
 		Meta: &Meta{
 			Exports: map[string]string{},
 		},
+		Logger:  nopLogger{},
 		plugins: plugins,
 	}
+	for _, o := range opts {
+		o(ic)
+	}
+	return ic
 }
 
 // Meta contains information gathered from the registration and initialization
@@ -67,16 +184,30 @@ type Plugin struct {
 
 	instance interface{}
 	err      error // will be set if there was an error initializing the plugin
+
+	// ic is the context the plugin was initialized with, retained so
+	// skipped plugins can be re-evaluated
+	ic *InitContext
+
+	// runtime state, set once the plugin transitions after initialization
+	mu           sync.Mutex
+	state        State
+	stateErr     error
+	transitioned bool
 }
 
 // Err returns the errors during initialization.
 // returns nil if no error was encountered
 func (p *Plugin) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.err
 }
 
 // Instance returns the instance and any initialization error of the plugin
 func (p *Plugin) Instance() (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.instance, p.err
 }
 
@@ -89,17 +220,66 @@ func (p *Plugin) Instance() (interface{}, error) {
 type Set struct {
 	ordered     []*Plugin // order of initialization
 	byTypeAndID map[Type]map[string]*Plugin
+	shims       map[Type][]Shim
+	parent      *Set
+	delegated   map[Type]bool
+	requires    map[*Plugin]map[*Plugin]struct{} // dependency graph, plugin to its dependencies
+
+	// lifecycle serializes runtime state transitions
+	lifecycle sync.Mutex
+	cascade   CascadePolicy
+	handlers  []func(Event)
+
+	errMu  sync.Mutex
+	errors map[string]*ErrorRecord
+
+	finMu      sync.Mutex
+	finalizers map[string][]finalizer
+
+	access AccessFunc
+
+	aliasHandlers []func(alias, uri string)
+
+	cfgMu     sync.Mutex
+	fragments map[string][]map[string]interface{}
+
+	snapMu   sync.Mutex
+	batching int
+	snapshot atomic.Pointer[Snapshot]
 }
 
+// SetOpt is an option used when creating a plugin Set
+type SetOpt func(*Set)
+
 // NewPluginSet returns an initialized plugin set
-func NewPluginSet() *Set {
-	return &Set{
+func NewPluginSet(opts ...SetOpt) *Set {
+	ps := &Set{
 		byTypeAndID: make(map[Type]map[string]*Plugin),
 	}
+	for _, o := range opts {
+		o(ps)
+	}
+	return ps
+}
+
+// WithParent delegates lookups for the given types to a parent set. Plugins
+// of delegated types are shared with the parent rather than initialized in
+// the child set, such as a content store shared with a nested daemon.
+func WithParent(parent *Set, types ...Type) SetOpt {
+	return func(ps *Set) {
+		ps.parent = parent
+		ps.delegated = make(map[Type]bool, len(types))
+		for _, t := range types {
+			ps.delegated[t] = true
+		}
+	}
 }
 
 // Add a plugin to the set
 func (ps *Set) Add(p *Plugin) error {
+	if ps.delegated[p.Registration.Type] {
+		return fmt.Errorf("plugin add failed for %s, type provided by parent set: %w", p.Registration.URI(), ErrPluginInitialized)
+	}
 	if byID, typeok := ps.byTypeAndID[p.Registration.Type]; !typeok {
 		ps.byTypeAndID[p.Registration.Type] = map[string]*Plugin{
 			p.Registration.ID: p,
@@ -111,52 +291,126 @@ func (ps *Set) Add(p *Plugin) error {
 	}
 
 	ps.ordered = append(ps.ordered, p)
+	ps.addEdges(p)
+	ps.publish()
+	return nil
+}
+
+// Remove removes a plugin from the set, such as a dynamically loaded plugin
+// being unloaded. Dependencies on the removed plugin are dropped from the
+// set's dependency graph.
+func (ps *Set) Remove(t Type, id string) error {
+	p, ok := ps.byTypeAndID[t][id]
+	if !ok {
+		return fmt.Errorf("plugin remove failed for %s.%s: %w", t, id, ErrPluginNotFound)
+	}
+	delete(ps.byTypeAndID[t], id)
+	if len(ps.byTypeAndID[t]) == 0 {
+		delete(ps.byTypeAndID, t)
+	}
+	for i, o := range ps.ordered {
+		if o == p {
+			ps.ordered = append(ps.ordered[:i:i], ps.ordered[i+1:]...)
+			break
+		}
+	}
+	ps.removeEdges(p)
+	ps.publish()
 	return nil
 }
 
 // Get returns the plugin with the given type and id
 func (ps *Set) Get(t Type, id string) *Plugin {
-	p, ok := ps.byTypeAndID[t]
-	if !ok {
-		return nil
+	if ps.delegated[t] {
+		return ps.parent.Get(t, id)
+	}
+	if p, ok := ps.byTypeAndID[t][id]; ok {
+		return p
+	}
+	for _, s := range ps.shims[t] {
+		if p, ok := ps.byTypeAndID[s.From][id]; ok {
+			return s.adapt(p)
+		}
+	}
+	alias := t.String() + "." + id
+	for _, p := range ps.ordered {
+		if p.Registration.HasAlias(alias) {
+			for _, fn := range ps.aliasHandlers {
+				fn(alias, p.Registration.URI())
+			}
+			return p
+		}
+	}
+	return nil
+}
+
+// WithAliasHandler adds a handler called when a plugin is looked up by one of
+// its deprecated aliases, such as to warn that the caller should be updated
+// to use the new URI.
+func WithAliasHandler(fn func(alias, uri string)) SetOpt {
+	return func(ps *Set) {
+		ps.aliasHandlers = append(ps.aliasHandlers, fn)
 	}
-	return p[id]
 }
 
-// GetAll returns all initialized plugins
+// GetAll returns all initialized plugins, not including plugins delegated
+// to a parent set
 func (ps *Set) GetAll() []*Plugin {
 	return ps.ordered
 }
 
 // GetSingle returns a plugin instance of the given type when only a single instance
 // of that type is expected. Throws an ErrPluginNotFound if no plugin is found and
-// ErrPluginMultipleInstances when multiple instances are found.
-// Since plugins are not ordered, if multiple instances is suported then
-// GetByType should be used. If only one is expected, then to switch plugins,
+// ErrPluginMultipleInstances when multiple instances are found, unless one of
+// them is registered as the Default for the type.
+// If multiple instances are supported then GetByType or GetByTypeOrdered
+// should be used. If only one is expected, then to switch plugins,
 // disable or remove the unused plugins of the same type.
 func (i *InitContext) GetSingle(t Type) (interface{}, error) {
+	p, err := i.getSingle(t)
+	if p == nil {
+		return nil, err
+	}
+	return p.Instance()
+}
+
+// getSingle returns the plugin GetSingle returns the instance of, along
+// with the initialization error of the plugin
+func (i *InitContext) getSingle(t Type) (*Plugin, error) {
 	var (
-		found    bool
-		instance interface{}
+		found, multiple bool
+		single, def     *Plugin
 	)
-	for _, v := range i.plugins.byTypeAndID[t] {
-		i, err := v.Instance()
-		if err != nil {
+	for _, v := range i.plugins.byType(t) {
+		if i.allowed(v) != nil {
+			continue
+		}
+		if _, err := v.Instance(); err != nil {
 			if IsSkipPlugin(err) {
 				continue
 			}
-			return i, err
+			return v, err
+		}
+		if v.Registration.Default {
+			def = v
 		}
 		if found {
-			return nil, fmt.Errorf("multiple plugins registered for %s: %w", t, ErrPluginMultipleInstances)
+			multiple = true
+			continue
 		}
-		instance = i
+		single = v
 		found = true
 	}
 	if !found {
 		return nil, fmt.Errorf("no plugins registered for %s: %w", t, ErrPluginNotFound)
 	}
-	return instance, nil
+	if multiple {
+		if def == nil {
+			return nil, fmt.Errorf("multiple plugins registered for %s: %w", t, ErrPluginMultipleInstances)
+		}
+		return def, nil
+	}
+	return single, nil
 }
 
 // Plugins returns plugin set
@@ -164,24 +418,68 @@ func (i *InitContext) Plugins() *Set {
 	return i.plugins
 }
 
-// GetAll plugins in the set
+// RegistrationGraph returns the effective registration order and filter
+// outcomes at the time of initialization, including plugins which will be
+// initialized after the current plugin. Returns nil if the graph was not
+// provided when creating the context.
+func (i *InitContext) RegistrationGraph() *RegistrationGraph {
+	return i.graph
+}
+
+// RemainingRegistrations returns the registrations which will be initialized
+// after the plugin being initialized with this context. Returns nil if the
+// graph was not provided or the context is not being used to initialize a
+// registration in the graph.
+func (i *InitContext) RemainingRegistrations() []*Registration {
+	if i.graph == nil || i.registration == nil {
+		return nil
+	}
+	return i.graph.After(i.registration.URI())
+}
+
+// GetAll plugins in the set which may be accessed by the caller
 func (i *InitContext) GetAll() []*Plugin {
-	return i.plugins.GetAll()
+	if i.plugins.access == nil {
+		return i.plugins.GetAll()
+	}
+	var plugins []*Plugin
+	for _, p := range i.plugins.GetAll() {
+		if i.allowed(p) == nil {
+			plugins = append(plugins, p)
+		}
+	}
+	return plugins
 }
 
 // GetByID returns the plugin of the given type and ID
 func (i *InitContext) GetByID(t Type, id string) (interface{}, error) {
+	p, err := i.getByID(t, id)
+	if err != nil {
+		return nil, err
+	}
+	return p.Instance()
+}
+
+// getByID returns the plugin of the given type and ID if the caller may
+// access it
+func (i *InitContext) getByID(t Type, id string) (*Plugin, error) {
 	p := i.plugins.Get(t, id)
 	if p == nil {
 		return nil, fmt.Errorf("no plugins registered for %s.%s: %w", t, id, ErrPluginNotFound)
 	}
-	return p.Instance()
+	if err := i.allowed(p); err != nil {
+		return nil, err
+	}
+	return p, nil
 }
 
 // GetByType returns all plugins with the specific type.
 func (i *InitContext) GetByType(t Type) (map[string]interface{}, error) {
 	pi := map[string]interface{}{}
-	for id, p := range i.plugins.byTypeAndID[t] {
+	for id, p := range i.plugins.byType(t) {
+		if i.allowed(p) != nil {
+			continue
+		}
 		i, err := p.Instance()
 		if err != nil {
 			if IsSkipPlugin(err) {
@@ -197,3 +495,86 @@ func (i *InitContext) GetByType(t Type) (map[string]interface{}, error) {
 
 	return pi, nil
 }
+
+// GetByTypeOrdered returns the loaded plugins with the specific type in the
+// order they were initialized, for consumers needing a deterministic choice
+// such as the first available plugin. Skipped plugins are omitted.
+func (i *InitContext) GetByTypeOrdered(t Type) ([]*Plugin, error) {
+	var plugins []*Plugin
+	for _, p := range i.plugins.orderedByType(t) {
+		if i.allowed(p) != nil {
+			continue
+		}
+		if _, err := p.Instance(); err != nil {
+			if IsSkipPlugin(err) {
+				continue
+			}
+			return nil, err
+		}
+		plugins = append(plugins, p)
+	}
+	if len(plugins) == 0 {
+		return nil, fmt.Errorf("no plugins registered for %s: %w", t, ErrPluginNotFound)
+	}
+	return plugins, nil
+}
+
+// PluginInstance is the instance of a loaded plugin along with its ID
+type PluginInstance struct {
+	ID       string
+	Instance interface{}
+}
+
+// GetAllByType returns the instances of the loaded plugins with the specific
+// type in the order they were initialized, which follows the registration
+// graph. Use it in place of GetByType when iterating deterministically, such
+// as to select a fallback. Skipped plugins are omitted.
+func (i *InitContext) GetAllByType(t Type) ([]PluginInstance, error) {
+	plugins, err := i.GetByTypeOrdered(t)
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]PluginInstance, 0, len(plugins))
+	for _, p := range plugins {
+		instance, _ := p.Instance()
+		instances = append(instances, PluginInstance{ID: p.Registration.ID, Instance: instance})
+	}
+	return instances, nil
+}
+
+// RequireCapability returns an error unless a loaded plugin of the given type
+// declares all of the capabilities. Plugins call this during init to fail
+// early with a clear error when a dependency lacks a required feature.
+func (i *InitContext) RequireCapability(t Type, capabilities ...string) error {
+	var candidates []string
+	for id, p := range i.plugins.byType(t) {
+		if _, err := p.Instance(); err != nil || i.allowed(p) != nil {
+			continue
+		}
+		missing := false
+		for _, c := range capabilities {
+			if !hasCapability(p.Meta.Capabilities, c) {
+				missing = true
+				break
+			}
+		}
+		if !missing {
+			return nil
+		}
+		candidates = append(candidates, fmt.Sprintf("%s%v", id, p.Meta.Capabilities))
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("no plugins registered for %s: %w", t, ErrPluginNotFound)
+	}
+	sort.Strings(candidates)
+	return fmt.Errorf("no %s plugin provides %v, loaded %s: %w", t, capabilities, strings.Join(candidates, ", "), ErrCapabilityNotFound)
+}
+
+func hasCapability(capabilities []string, c string) bool {
+	for _, o := range capabilities {
+		if o == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"strings"
+)
+
+// CycleError is returned when the ordering constraints of plugins form a
+// cycle, listing each plugin on the cycle.
+type CycleError struct {
+	// Path is the URIs of the plugins on the cycle, each depending on the
+	// next, starting and ending with the same plugin
+	Path []string
+}
+
+func (e *CycleError) Error() string {
+	return strings.Join(e.Path, " -> ") + ": " + ErrPluginCircularDependency.Error()
+}
+
+// Unwrap returns ErrPluginCircularDependency
+func (e *CycleError) Unwrap() error {
+	return ErrPluginCircularDependency
+}
+
+// cycleTo returns the cycle from reg through the registrations being added
+// back to reg
+func (w *walker) cycleTo(reg *Registration) *CycleError {
+	e := &CycleError{}
+	for i, r := range w.stack {
+		if r == reg {
+			for _, r := range w.stack[i:] {
+				e.Path = append(e.Path, r.URI())
+			}
+			break
+		}
+	}
+	e.Path = append(e.Path, reg.URI())
+	return e
+}
+
+// cycles returns a CycleError for each strongly connected group of enabled
+// registrations, so every independent cycle is reported at once.
+func (w *walker) cycles() error {
+	var (
+		index   = map[*Registration]int{}
+		low     = map[*Registration]int{}
+		onStack = map[*Registration]bool{}
+		stack   []*Registration
+		errs    []error
+		visit   func(*Registration)
+	)
+	visit = func(r *Registration) {
+		index[r] = len(index)
+		low[r] = index[r]
+		stack = append(stack, r)
+		onStack[r] = true
+		w.dependencies(r, true, func(dep *Registration) {
+			if _, ok := index[dep]; !ok {
+				visit(dep)
+				if low[dep] < low[r] {
+					low[r] = low[dep]
+				}
+			} else if onStack[dep] && index[dep] < low[r] {
+				low[r] = index[dep]
+			}
+		})
+		if low[r] != index[r] {
+			return
+		}
+		component := map[*Registration]bool{}
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component[top] = true
+			if top == r {
+				break
+			}
+		}
+		if len(component) > 1 {
+			errs = append(errs, w.shortestCycle(r, component))
+		}
+	}
+	for _, r := range w.registry {
+		if _, ok := index[r]; !ok && !w.disabled[r] {
+			visit(r)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// shortestCycle returns the shortest cycle from start back to itself within
+// the strongly connected component
+func (w *walker) shortestCycle(start *Registration, component map[*Registration]bool) *CycleError {
+	parent := map[*Registration]*Registration{}
+	queue := []*Registration{start}
+	for len(queue) > 0 {
+		r := queue[0]
+		queue = queue[1:]
+		found := false
+		w.dependencies(r, true, func(dep *Registration) {
+			if found || !component[dep] {
+				return
+			}
+			if dep == start {
+				parent[start] = r
+				found = true
+				return
+			}
+			if _, ok := parent[dep]; !ok {
+				parent[dep] = r
+				queue = append(queue, dep)
+			}
+		})
+		if found {
+			break
+		}
+	}
+	path := []string{start.URI()}
+	for r := parent[start]; r != start; r = parent[r] {
+		path = append(path, r.URI())
+	}
+	path = append(path, start.URI())
+	// path was built from the end of the cycle, reverse it so each plugin
+	// depends on the next
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return &CycleError{Path: path}
+}
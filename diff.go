@@ -0,0 +1,136 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+// DiffReport describes how the plugin topology changed between registries
+type DiffReport struct {
+	// Added is the URIs of the plugins only registered in the new registry
+	Added []string
+	// Removed is the URIs of the plugins only registered in the old registry
+	Removed []string
+	// Reordered is the URIs of the plugins registered in both registries
+	// whose position relative to the other common plugins changed, being
+	// those outside the longest common subsequence of both orders
+	Reordered []string
+	// AddedEdges are the edges only in the new registry
+	AddedEdges []GraphEdge
+	// RemovedEdges are the edges only in the old registry
+	RemovedEdges []GraphEdge
+}
+
+// Diff compares the graphs of the old and updated registries with every plugin enabled, such
+// as the built-in plugins of two releases. Panics in the same way as Graph if
+// either graph cannot be computed.
+func Diff(old, updated Registry, opts ...GraphOpt) DiffReport {
+	var (
+		report    DiffReport
+		enabled   = func(*Registration) bool { return false }
+		oldGraph  = old.DependencyGraph(enabled, opts...)
+		newGraph  = updated.DependencyGraph(enabled, opts...)
+		oldURIs   = uriSet(oldGraph.Nodes)
+		newURIs   = uriSet(newGraph.Nodes)
+		oldCommon []string
+		newCommon []string
+	)
+	for _, r := range oldGraph.Nodes {
+		if newURIs[r.URI()] {
+			oldCommon = append(oldCommon, r.URI())
+		} else {
+			report.Removed = append(report.Removed, r.URI())
+		}
+	}
+	for _, r := range newGraph.Nodes {
+		if oldURIs[r.URI()] {
+			newCommon = append(newCommon, r.URI())
+		} else {
+			report.Added = append(report.Added, r.URI())
+		}
+	}
+	kept := longestCommonSubsequence(oldCommon, newCommon)
+	for _, uri := range newCommon {
+		if !kept[uri] {
+			report.Reordered = append(report.Reordered, uri)
+		}
+	}
+	report.AddedEdges = edgesNotIn(newGraph.Edges, oldGraph.Edges)
+	report.RemovedEdges = edgesNotIn(oldGraph.Edges, newGraph.Edges)
+	return report
+}
+
+// Empty returns true if the registries have the same topology
+func (d DiffReport) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Reordered) == 0 && len(d.AddedEdges) == 0 && len(d.RemovedEdges) == 0
+}
+
+// longestCommonSubsequence returns the URIs of a longest subsequence common
+// to both orders, preferring to keep the later plugins of the old order when
+// several subsequences have the same length
+func longestCommonSubsequence(old, updated []string) map[string]bool {
+	// length[i][j] is the length of the subsequence of old[i:] and updated[j:]
+	length := make([][]int, len(old)+1)
+	for i := range length {
+		length[i] = make([]int, len(updated)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(updated) - 1; j >= 0; j-- {
+			switch {
+			case old[i] == updated[j]:
+				length[i][j] = length[i+1][j+1] + 1
+			case length[i+1][j] >= length[i][j+1]:
+				length[i][j] = length[i+1][j]
+			default:
+				length[i][j] = length[i][j+1]
+			}
+		}
+	}
+	kept := make(map[string]bool, length[0][0])
+	for i, j := 0, 0; i < len(old) && j < len(updated); {
+		switch {
+		case old[i] == updated[j]:
+			kept[old[i]] = true
+			i++
+			j++
+		case length[i+1][j] >= length[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return kept
+}
+
+func uriSet(regs []*Registration) map[string]bool {
+	uris := make(map[string]bool, len(regs))
+	for _, r := range regs {
+		uris[r.URI()] = true
+	}
+	return uris
+}
+
+func edgesNotIn(edges, other []GraphEdge) []GraphEdge {
+	existing := make(map[GraphEdge]bool, len(other))
+	for _, e := range other {
+		existing[e] = true
+	}
+	var diff []GraphEdge
+	for _, e := range edges {
+		if !existing[e] {
+			diff = append(diff, e)
+		}
+	}
+	return diff
+}
@@ -0,0 +1,84 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"sort"
+	"time"
+)
+
+// ErrorRecord aggregates the runtime errors reported by a plugin
+type ErrorRecord struct {
+	// Plugin is the URI of the reporting plugin
+	Plugin string
+	// Count is the number of errors reported
+	Count int
+	// LastError is the most recently reported error
+	LastError error
+	// LastTime is when the most recent error was reported
+	LastTime time.Time
+}
+
+// ReportError records a runtime error for the plugin being initialized with
+// this context. Plugins may retain the context and report errors after
+// initialization; the errors are aggregated by the plugin set for reporting
+// degraded plugins. Errors reported outside of Registration.Init, where the
+// plugin is unknown, are ignored.
+func (i *InitContext) ReportError(err error) {
+	if err == nil || i.registration == nil || i.plugins == nil {
+		return
+	}
+	i.plugins.recordError(i.registration.URI(), err)
+}
+
+func (ps *Set) recordError(uri string, err error) {
+	ps.errMu.Lock()
+	defer ps.errMu.Unlock()
+	if ps.errors == nil {
+		ps.errors = map[string]*ErrorRecord{}
+	}
+	rec, ok := ps.errors[uri]
+	if !ok {
+		rec = &ErrorRecord{Plugin: uri}
+		ps.errors[uri] = rec
+	}
+	rec.Count++
+	rec.LastError = err
+	rec.LastTime = time.Now()
+}
+
+// Errors returns the aggregated runtime errors of each plugin which has
+// reported errors, sorted by plugin URI.
+func (ps *Set) Errors() []ErrorRecord {
+	ps.errMu.Lock()
+	defer ps.errMu.Unlock()
+	records := make([]ErrorRecord, 0, len(ps.errors))
+	for _, rec := range ps.errors {
+		records = append(records, *rec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Plugin < records[j].Plugin
+	})
+	return records
+}
+
+// ResetErrors clears the recorded errors for the plugin with the given URI
+func (ps *Set) ResetErrors(uri string) {
+	ps.errMu.Lock()
+	defer ps.errMu.Unlock()
+	delete(ps.errors, uri)
+}
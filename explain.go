@@ -0,0 +1,187 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+// EdgeKind is the kind of constraint ordering a plugin after another
+type EdgeKind string
+
+const (
+	// EdgeRequires is a Requires entry of the plugin
+	EdgeRequires EdgeKind = "requires"
+	// EdgeOptional is an Optional entry of the plugin
+	EdgeOptional EdgeKind = "optional"
+	// EdgeBarrier is a Barrier entry of the plugin
+	EdgeBarrier EdgeKind = "barrier"
+	// EdgeNeeds is a capability in Needs of the plugin
+	EdgeNeeds EdgeKind = "needs"
+	// EdgeBefore is a Before entry of the dependency
+	EdgeBefore EdgeKind = "before"
+	// EdgeConfigFor is a ConfigFor entry of the dependency
+	EdgeConfigFor EdgeKind = "config for"
+	// EdgeOrderHint is an order hint given with WithOrderHints
+	EdgeOrderHint EdgeKind = "order hint"
+)
+
+// Edge is a plugin which must be initialized before another plugin
+type Edge struct {
+	// Dependency is the URI of the plugin initialized first
+	Dependency string
+	// Kind is the kind of constraint creating the edge
+	Kind EdgeKind
+	// Entry is the constraint or capability creating the edge, empty for
+	// order hints
+	Entry Type
+	// Wildcard is set when the edge is created by a "*" entry
+	Wildcard bool
+}
+
+// Explanation describes why a plugin is at its position in the graph
+type Explanation struct {
+	// Plugin is the URI of the plugin
+	Plugin string
+	// Position is the index of the plugin in the ordered graph
+	Position int
+	// Edges are the plugins which must be initialized before the plugin
+	Edges []Edge
+	// PulledBy is the URI of the plugin which required the plugin to be
+	// initialized ahead of its registration order, empty if the plugin is
+	// at its position due to registration order or Priority.
+	PulledBy string
+}
+
+// Tiebreak returns true if the position of the plugin follows from its
+// registration order or Priority rather than from a dependent plugin
+func (e Explanation) Tiebreak() bool {
+	return e.PulledBy == ""
+}
+
+// Explain computes the ordered list of registrations in the same way as
+// GraphRefs and returns an explanation of the position of each plugin.
+func (registry Registry) Explain(filter DisableFilter, opts ...GraphOpt) []Explanation {
+	var (
+		o       = newGraphOptions(opts)
+		ordered []*Registration
+	)
+	w, _ := registry.walker(registry.disabled(filter, o), o, func(r *Registration) {
+		ordered = append(ordered, r)
+	})
+	explanations := make([]Explanation, 0, len(w.registry))
+	for _, r := range w.registry {
+		if w.disabled[r] || w.added[r] {
+			continue
+		}
+		start := len(ordered)
+		w.add(r)
+		for _, pulled := range ordered[start:] {
+			e := Explanation{Plugin: pulled.URI(), Position: len(explanations)}
+			if pulled != r {
+				e.PulledBy = r.URI()
+			}
+			seen := map[Edge]bool{}
+			w.edges(pulled, true, func(dep *Registration, kind EdgeKind, t Type) {
+				edge := Edge{Dependency: dep.URI(), Kind: kind, Entry: t, Wildcard: t == "*"}
+				if !seen[edge] {
+					seen[edge] = true
+					e.Edges = append(e.Edges, edge)
+				}
+			})
+			explanations = append(explanations, e)
+		}
+	}
+	return explanations
+}
+
+// DependencyGraph is the ordered list of enabled registrations along with
+// the resolved edges between them
+type DependencyGraph struct {
+	// Nodes are the enabled registrations in initialization order
+	Nodes []*Registration
+	// Edges are the resolved edges between the nodes, ordered by the
+	// position of the dependent plugin
+	Edges []GraphEdge
+	// Roots are the nodes without dependencies
+	Roots []*Registration
+	// Leaves are the nodes no other node depends on
+	Leaves []*Registration
+}
+
+// GraphEdge is an edge from a plugin to a plugin initialized before it
+type GraphEdge struct {
+	// Plugin is the URI of the dependent plugin
+	Plugin string
+	Edge
+}
+
+// DependencyGraph computes the ordered list of registrations in the same
+// way as GraphRefs along with the edges between them.
+func (registry Registry) DependencyGraph(filter DisableFilter, opts ...GraphOpt) *DependencyGraph {
+	var (
+		o = newGraphOptions(opts)
+		g = &DependencyGraph{}
+	)
+	w, _ := registry.walker(registry.disabled(filter, o), o, func(r *Registration) {
+		g.Nodes = append(g.Nodes, r)
+	})
+	for _, r := range w.registry {
+		if !w.disabled[r] {
+			w.add(r)
+		}
+	}
+	dependedOn := map[*Registration]bool{}
+	for _, r := range g.Nodes {
+		seen := map[Edge]bool{}
+		w.edges(r, true, func(dep *Registration, kind EdgeKind, t Type) {
+			dependedOn[dep] = true
+			edge := Edge{Dependency: dep.URI(), Kind: kind, Entry: t, Wildcard: t == "*"}
+			if !seen[edge] {
+				seen[edge] = true
+				g.Edges = append(g.Edges, GraphEdge{Plugin: r.URI(), Edge: edge})
+			}
+		})
+		if len(seen) == 0 {
+			g.Roots = append(g.Roots, r)
+		}
+	}
+	for _, r := range g.Nodes {
+		if !dependedOn[r] {
+			g.Leaves = append(g.Leaves, r)
+		}
+	}
+	return g
+}
+
+// Dependencies returns the edges from the plugin with the given URI
+func (g *DependencyGraph) Dependencies(uri string) []GraphEdge {
+	var edges []GraphEdge
+	for _, e := range g.Edges {
+		if e.Plugin == uri {
+			edges = append(edges, e)
+		}
+	}
+	return edges
+}
+
+// Dependents returns the edges to the plugin with the given URI
+func (g *DependencyGraph) Dependents(uri string) []GraphEdge {
+	var edges []GraphEdge
+	for _, e := range g.Edges {
+		if e.Dependency == uri {
+			edges = append(edges, e)
+		}
+	}
+	return edges
+}
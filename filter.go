@@ -0,0 +1,121 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// EnableOnly returns a filter disabling every registration other than those
+// matching the given plugin URIs or types and their transitive Requires,
+// Optional and Needs dependencies, such as for a minimal daemon only serving
+// content. Plugins only ordered before a target, by a barrier, a Before entry
+// or an init hint, stay disabled. The filter is computed from the registry at
+// the time of the call.
+func (registry Registry) EnableOnly(uris ...string) DisableFilter {
+	targets := make([]Type, 0, len(uris))
+	for _, uri := range uris {
+		targets = append(targets, Type(uri))
+	}
+	enabled := map[string]bool{}
+	for _, r := range registry.closure(targets) {
+		enabled[r.URI()] = true
+	}
+	return func(r *Registration) bool {
+		return !enabled[r.URI()]
+	}
+}
+
+// DisableGlobs returns a filter disabling the registrations whose URI
+// matches any of the glob patterns, such as "io.containerd.grpc.v1.*".
+// Patterns use the syntax of path.Match and are validated when the filter
+// is created.
+func DisableGlobs(patterns ...string) (DisableFilter, error) {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", p, err)
+		}
+	}
+	return func(r *Registration) bool {
+		uri := r.URI()
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, uri); ok {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// DisableRegexps returns a filter disabling the registrations whose URI
+// matches any of the regular expressions. Expressions are not anchored, use
+// "^" and "$" to match the whole URI.
+func DisableRegexps(patterns ...string) (DisableFilter, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp %q: %w", p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return func(r *Registration) bool {
+		uri := r.URI()
+		for _, re := range compiled {
+			if re.MatchString(uri) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// FilterAnd returns a filter disabling registrations disabled by every one
+// of the filters
+func FilterAnd(filters ...DisableFilter) DisableFilter {
+	return func(r *Registration) bool {
+		for _, f := range filters {
+			if !f(r) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// FilterOr returns a filter disabling registrations disabled by any of the
+// filters, such as a platform filter combined with the configured disabled
+// plugins
+func FilterOr(filters ...DisableFilter) DisableFilter {
+	return func(r *Registration) bool {
+		for _, f := range filters {
+			if f(r) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// FilterNot returns a filter disabling the registrations enabled by filter
+func FilterNot(filter DisableFilter) DisableFilter {
+	return func(r *Registration) bool {
+		return !filter(r)
+	}
+}
@@ -0,0 +1,78 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+type finalizer struct {
+	name string
+	fn   func(context.Context) error
+}
+
+// AddFinalizer registers a function cleaning up a disposable resource created
+// by the plugin being initialized with this context, such as a temporary
+// mount or cache. Finalizers are tracked per plugin by the set and run by
+// Set.Finalize on shutdown or Set.GC, in reverse order of registration.
+// Finalizers added outside of Registration.Init are ignored.
+func (i *InitContext) AddFinalizer(name string, fn func(context.Context) error) {
+	if i.registration == nil || i.plugins == nil {
+		return
+	}
+	ps := i.plugins
+	uri := i.registration.URI()
+	ps.finMu.Lock()
+	defer ps.finMu.Unlock()
+	if ps.finalizers == nil {
+		ps.finalizers = map[string][]finalizer{}
+	}
+	ps.finalizers[uri] = append(ps.finalizers[uri], finalizer{name: name, fn: fn})
+}
+
+// GC runs and removes the finalizers of the plugin with the given URI,
+// returning the errors of any failed finalizers.
+func (ps *Set) GC(ctx context.Context, uri string) error {
+	ps.finMu.Lock()
+	finalizers := ps.finalizers[uri]
+	delete(ps.finalizers, uri)
+	ps.finMu.Unlock()
+
+	var errs []error
+	for i := len(finalizers) - 1; i >= 0; i-- {
+		f := finalizers[i]
+		if err := f.fn(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("finalizer %q for %s failed: %w", f.name, uri, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Finalize runs the finalizers of all plugins in reverse initialization
+// order, so resources of dependents are cleaned up before the resources of
+// the plugins they depend on. Errors from every failed finalizer are joined.
+func (ps *Set) Finalize(ctx context.Context) error {
+	var errs []error
+	for i := len(ps.ordered) - 1; i >= 0; i-- {
+		if err := ps.GC(ctx, ps.ordered[i].Registration.URI()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,794 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// GraphOpt is an option used when computing the registration graph
+type GraphOpt func(*graphOptions)
+
+type graphOptions struct {
+	ctx              context.Context
+	hints            []OrderHint
+	wildcardExclude  map[Type]bool
+	disableConflicts bool
+	strictRequires   bool
+	cascadeDisable   bool
+	sortByURI        bool
+}
+
+func newGraphOptions(opts []GraphOpt) *graphOptions {
+	o := &graphOptions{ctx: context.Background()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// disabled returns true if the registration is disabled by the filter or
+// its EnabledFn
+func (o *graphOptions) disabled(filter DisableFilter, r *Registration) bool {
+	return filter(r) || (r.EnabledFn != nil && !r.EnabledFn(o.ctx))
+}
+
+// WithContext sets the context passed to the EnabledFn of registrations
+func WithContext(ctx context.Context) GraphOpt {
+	return func(o *graphOptions) {
+		o.ctx = ctx
+	}
+}
+
+// OrderHint pins the plugin with URI First to be initialized before the
+// plugin with URI Then. Hints preserve historical ordering, such as the
+// registration order of snapshotters affecting default selection, across
+// changes to the graph algorithm. Hints referring to plugins which are not
+// registered or are disabled are ignored.
+type OrderHint struct {
+	First string
+	Then  string
+}
+
+// WithOrderHints pins the relative order of plugins. A hint which
+// contradicts the dependencies of the plugins causes Graph to panic with
+// ErrInvalidOrderHint.
+func WithOrderHints(hints ...OrderHint) GraphOpt {
+	return func(o *graphOptions) {
+		o.hints = append(o.hints, hints...)
+	}
+}
+
+// WithWildcardExclude excludes plugins of the given types from "*"
+// constraints, so wildcard dependents such as introspection are not ordered
+// behind plugins they never use. Embedders of a trimmed daemon use this to
+// define what "*" means for their registry.
+func WithWildcardExclude(types ...Type) GraphOpt {
+	return func(o *graphOptions) {
+		if o.wildcardExclude == nil {
+			o.wildcardExclude = map[Type]bool{}
+		}
+		for _, t := range types {
+			o.wildcardExclude[t] = true
+		}
+	}
+}
+
+// WithConflictAutoDisable disables plugins conflicting with an enabled plugin
+// with a higher Priority, or registered before them with the same priority,
+// rather than failing with ErrPluginConflict. The
+// decisions are recorded in the Conflicts of the graph returned by Resolve.
+func WithConflictAutoDisable() GraphOpt {
+	return func(o *graphOptions) {
+		o.disableConflicts = true
+	}
+}
+
+// WithStrictRequires fails the graph with an UnsatisfiedRequirementError
+// when a Requires entry of an enabled plugin matches no enabled plugin,
+// rather than leaving the plugin to fail with ErrPluginNotFound at runtime.
+func WithStrictRequires() GraphOpt {
+	return func(o *graphOptions) {
+		o.strictRequires = true
+	}
+}
+
+// WithCascadeDisable disables every plugin requiring a disabled plugin,
+// transitively, rather than leaving it to fail with ErrPluginNotFound at
+// runtime. A plugin is disabled when a Requires entry or needed capability
+// is only provided by disabled plugins. The decisions are recorded in the
+// Cascades of the graph returned by Resolve.
+func WithCascadeDisable() GraphOpt {
+	return func(o *graphOptions) {
+		o.cascadeDisable = true
+	}
+}
+
+// Cascade is a plugin disabled because a plugin it requires was disabled
+type Cascade struct {
+	// Disabled is the URI of the disabled plugin
+	Disabled string
+	// Chain is the URIs of the plugins from the plugin disabled by the
+	// filter to the disabled plugin, each required by the next
+	Chain []string
+}
+
+// cascade disables each enabled registration with a requirement only
+// provided by disabled registrations until no more are disabled.
+func (w *walker) cascade() {
+	chains := map[*Registration][]string{}
+	chain := func(r *Registration) []string {
+		if c, ok := chains[r]; ok {
+			return c
+		}
+		return []string{r.URI()}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, r := range w.registry {
+			if w.disabled[r] {
+				continue
+			}
+			if cause := w.unsatisfiedBy(r); cause != nil {
+				w.disabled[r] = true
+				c := append(append([]string{}, chain(cause)...), r.URI())
+				chains[r] = c
+				w.cascades = append(w.cascades, Cascade{Disabled: r.URI(), Chain: c})
+				changed = true
+			}
+		}
+	}
+}
+
+// unsatisfiedBy returns a disabled registration providing a requirement of
+// r which no enabled registration provides, nil if every requirement which
+// has a provider has an enabled provider.
+func (w *walker) unsatisfiedBy(r *Registration) *Registration {
+	provides := func(match func(*Registration) bool) *Registration {
+		var cause *Registration
+		for _, other := range w.registry {
+			if other == r || !match(other) {
+				continue
+			}
+			if !w.disabled[other] {
+				return nil
+			}
+			if cause == nil {
+				cause = other
+			}
+		}
+		return cause
+	}
+	for _, t := range r.Requires {
+		if t == "*" {
+			continue
+		}
+		if cause := provides(func(other *Registration) bool { return w.matches(t, other) }); cause != nil {
+			return cause
+		}
+	}
+	for _, need := range r.Needs {
+		if cause := provides(func(other *Registration) bool { return other.Satisfies(need) }); cause != nil {
+			return cause
+		}
+	}
+	return nil
+}
+
+// WithSortedOrder orders plugins which do not depend on each other by URI
+// rather than by registration order, which follows Go import order, so the
+// graph is stable across refactors such as for golden-file tests. Priority
+// still takes precedence over the URI.
+func WithSortedOrder() GraphOpt {
+	return func(o *graphOptions) {
+		o.sortByURI = true
+	}
+}
+
+// Conflict is a plugin disabled because it conflicts with another plugin
+type Conflict struct {
+	// Disabled is the URI of the disabled plugin
+	Disabled string
+	// Kept is the URI of the enabled plugin it conflicts with
+	Kept string
+}
+
+// pin records the order hints between enabled registrations, panicking if
+// a hint contradicts the dependencies of the registrations.
+func (w *walker) pin(hints []OrderHint) {
+	if len(hints) == 0 {
+		return
+	}
+	byURI := map[string]*Registration{}
+	for _, r := range w.registry {
+		if !w.disabled[r] {
+			byURI[r.URI()] = r
+		}
+	}
+	w.pinned = map[*Registration][]*Registration{}
+	for _, h := range hints {
+		first, then := byURI[h.First], byURI[h.Then]
+		if first == nil || then == nil {
+			continue
+		}
+		if w.reachable(first, then, false) {
+			panic(fmt.Errorf("%s before %s: %s depends on %s: %w", h.First, h.Then, h.First, h.Then, ErrInvalidOrderHint))
+		}
+		w.pinned[then] = append(w.pinned[then], first)
+	}
+}
+
+// soft pins the InitAfter and InitBefore hints of enabled registrations,
+// skipping hints which would create a cycle with the other constraints.
+func (w *walker) soft() {
+	hint := func(first, then *Registration) {
+		if first == then || w.disabled[first] || w.disabled[then] || w.reachable(first, then, true) {
+			return
+		}
+		if w.pinned == nil {
+			w.pinned = map[*Registration][]*Registration{}
+		}
+		w.pinned[then] = append(w.pinned[then], first)
+	}
+	for _, r := range w.registry {
+		if w.disabled[r] || (len(r.InitAfter) == 0 && len(r.InitBefore) == 0) {
+			continue
+		}
+		for _, other := range w.registry {
+			if w.matchesAny(r.InitAfter, other) {
+				hint(other, r)
+			}
+			if w.matchesAny(r.InitBefore, other) {
+				hint(r, other)
+			}
+		}
+	}
+}
+
+// matchesAny returns true if any of the type constraints applies to r
+func (w *walker) matchesAny(types []Type, r *Registration) bool {
+	for _, t := range types {
+		if w.matches(t, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// reachable returns true if to must be initialized before from based on the
+// dependencies of the registrations, including order hints when pinned is set.
+func (w *walker) reachable(from, to *Registration, pinned bool) bool {
+	seen := map[*Registration]bool{}
+	var visit func(*Registration)
+	found := false
+	visit = func(r *Registration) {
+		if found || seen[r] {
+			return
+		}
+		seen[r] = true
+		if r == to {
+			found = true
+			return
+		}
+		w.dependencies(r, pinned, visit)
+	}
+	w.dependencies(from, pinned, visit)
+	return found
+}
+
+// GraphUpdate is the outcome of re-resolving a graph after plugins were
+// enabled or disabled at runtime.
+type GraphUpdate struct {
+	// Graph is the updated registration graph
+	Graph *RegistrationGraph
+	// Stop lists the plugins of the previous graph to stop, in reverse
+	// initialization order
+	Stop []*Registration
+	// Start lists the plugins to start after stopping, in initialization
+	// order
+	Start []*Registration
+}
+
+// Update re-evaluates the filter for the plugins with the given URIs against
+// a graph previously returned by Resolve. Only the plugins whose disabled
+// state changed, including plugins disabled or re-enabled to resolve
+// conflicts or by cascade, and their transitive dependents are re-resolved.
+// All other plugins keep their position and are not included in the
+// returned actions. The same options used to resolve the graph must be
+// given, they are applied in the same way as by Resolve.
+func (registry Registry) Update(g *RegistrationGraph, filter DisableFilter, uris []string, opts ...GraphOpt) *GraphUpdate {
+	toggled := map[string]bool{}
+	for _, uri := range uris {
+		toggled[uri] = true
+	}
+	// Plugins disabled by the graph options are re-evaluated by the walker,
+	// only the plugins disabled by the filter are carried over
+	byOptions := map[string]bool{}
+	for _, c := range g.Conflicts {
+		byOptions[c.Disabled] = true
+	}
+	for _, c := range g.Cascades {
+		byOptions[c.Disabled] = true
+	}
+	wasDisabled := make(map[*Registration]bool, len(g.Disabled))
+	filtered := map[*Registration]bool{}
+	for _, r := range g.Disabled {
+		wasDisabled[r] = true
+		if !byOptions[r.URI()] {
+			filtered[r] = true
+		}
+	}
+	o := newGraphOptions(opts)
+	changed := false
+	for _, r := range registry {
+		if toggled[r.URI()] && o.disabled(filter, r) != filtered[r] {
+			filtered[r] = !filtered[r]
+			changed = true
+		}
+	}
+	if !changed {
+		return &GraphUpdate{Graph: g}
+	}
+
+	update := &GraphUpdate{Graph: &RegistrationGraph{}}
+	w, conflicts := registry.walker(filtered, o, func(r *Registration) {
+		update.Start = append(update.Start, r)
+	})
+	update.Graph.Conflicts, update.Graph.Cascades = conflicts, w.cascades
+
+	var flipped []*Registration
+	for _, r := range registry {
+		if w.disabled[r] != wasDisabled[r] {
+			flipped = append(flipped, r)
+		}
+	}
+	affected := w.dependents(flipped, false)
+	for i := len(g.Ordered) - 1; i >= 0; i-- {
+		if r := g.Ordered[i]; affected[r] {
+			update.Stop = append(update.Stop, r)
+		}
+	}
+	for _, r := range g.Ordered {
+		if !affected[r] {
+			w.added[r] = true
+			update.Graph.Ordered = append(update.Graph.Ordered, r)
+		}
+	}
+	for _, r := range w.registry {
+		if affected[r] && !w.disabled[r] {
+			w.add(r)
+		}
+	}
+	for _, r := range registry {
+		if w.disabled[r] {
+			update.Graph.Disabled = append(update.Graph.Disabled, r)
+		}
+	}
+	update.Graph.Ordered = append(update.Graph.Ordered, update.Start...)
+	return update
+}
+
+// Dependents returns the registrations which transitively depend on the
+// registration with the given type and ID through Requires, Optional or
+// Needs entries, in registration order, such as to find the plugins affected
+// by disabling it. Barriers and ordering constraints such as Before and order
+// hints are not followed. Disabled state is not considered, every
+// registration is assumed to be enabled.
+func (registry Registry) Dependents(t Type, id string) []*Registration {
+	target, ok := registry.Get(t, id)
+	if !ok {
+		return nil
+	}
+	w := &walker{registry: registry}
+	affected := w.dependents([]*Registration{target}, true)
+	var dependents []*Registration
+	for _, r := range registry {
+		if r != target && affected[r] {
+			dependents = append(dependents, r)
+		}
+	}
+	return dependents
+}
+
+// Subgraph returns the registrations matching the targets along with their
+// transitive Requires, Optional and Needs dependencies in initialization
+// order, such as to initialize only a content store without the rest of the
+// registry. Registrations which are not needed by a target are omitted, even
+// when ordered before it by a barrier, a Before entry or an order hint.
+func (registry Registry) Subgraph(targets ...Type) []Registration {
+	var ordered []Registration
+	for _, r := range registry.closure(targets) {
+		ordered = append(ordered, *r)
+	}
+	return ordered
+}
+
+// closure returns the registrations matching the targets and their
+// transitive hard dependencies, ordered by every edge between them
+func (registry Registry) closure(targets []Type) []*Registration {
+	all := &walker{registry: registry, disabled: map[*Registration]bool{}}
+	needed := map[*Registration]bool{}
+	var visit func(*Registration)
+	visit = func(r *Registration) {
+		if needed[r] {
+			return
+		}
+		needed[r] = true
+		all.hardDependencies(r, visit)
+	}
+	for _, r := range registry {
+		for _, t := range targets {
+			if t.Matches(r) {
+				visit(r)
+				break
+			}
+		}
+	}
+	disabled := map[*Registration]bool{}
+	for _, r := range registry {
+		if !needed[r] {
+			disabled[r] = true
+		}
+	}
+	var ordered []*Registration
+	registry.walk(disabled, newGraphOptions(nil), func(r *Registration) {
+		ordered = append(ordered, r)
+	})
+	return ordered
+}
+
+// Stages groups the ordered list computed by Graph into stages, where the
+// plugins of a stage only depend on plugins in earlier stages and may be
+// initialized in parallel. Plugins keep their graph order within a stage.
+func (registry Registry) Stages(filter DisableFilter, opts ...GraphOpt) [][]Registration {
+	refs := registry.stages(filter, newGraphOptions(opts))
+	stages := make([][]Registration, len(refs))
+	for i, stage := range refs {
+		for _, r := range stage {
+			stages[i] = append(stages[i], *r)
+		}
+	}
+	return stages
+}
+
+// stages returns the stages computed by Stages as references to the
+// registrations
+func (registry Registry) stages(filter DisableFilter, o *graphOptions) [][]*Registration {
+	var ordered []*Registration
+	w, _ := registry.walker(registry.disabled(filter, o), o, func(r *Registration) {
+		ordered = append(ordered, r)
+	})
+	for _, r := range w.registry {
+		if !w.disabled[r] {
+			w.add(r)
+		}
+	}
+	var (
+		stages [][]*Registration
+		level  = make(map[*Registration]int, len(ordered))
+	)
+	for _, r := range ordered {
+		l := 0
+		w.dependencies(r, true, func(dep *Registration) {
+			if level[dep]+1 > l {
+				l = level[dep] + 1
+			}
+		})
+		level[r] = l
+		if l == len(stages) {
+			stages = append(stages, nil)
+		}
+		stages[l] = append(stages[l], r)
+	}
+	return stages
+}
+
+// dependents returns the registrations along with every registration which
+// transitively depends on them, regardless of whether they are disabled. When
+// hard is set only Requires, Optional and Needs entries are followed,
+// otherwise every edge ordering a registration after another is.
+func (w *walker) dependents(regs []*Registration, hard bool) map[*Registration]bool {
+	all := &walker{
+		registry:        w.registry,
+		disabled:        map[*Registration]bool{},
+		pinned:          w.pinned,
+		wildcardExclude: w.wildcardExclude,
+		unchecked:       true,
+	}
+	reverse := map[*Registration][]*Registration{}
+	for _, r := range w.registry {
+		r := r
+		link := func(dep *Registration) {
+			reverse[dep] = append(reverse[dep], r)
+		}
+		if hard {
+			all.hardDependencies(r, link)
+		} else {
+			all.dependencies(r, true, link)
+		}
+	}
+	affected := map[*Registration]bool{}
+	var visit func(*Registration)
+	visit = func(r *Registration) {
+		if affected[r] {
+			return
+		}
+		affected[r] = true
+		for _, d := range reverse[r] {
+			visit(d)
+		}
+	}
+	for _, r := range regs {
+		visit(r)
+	}
+	return affected
+}
+
+// conflicts panics with ErrPluginConflict if any enabled registrations
+// declare a conflict with each other, reporting every conflicting pair. When
+// disable is set conflicting registrations are disabled instead.
+func (w *walker) conflicts(disable bool) []Conflict {
+	if disable {
+		return w.disableConflicts()
+	}
+	var (
+		errs []error
+		seen = map[[2]*Registration]bool{}
+	)
+	for _, r := range w.registry {
+		if w.disabled[r] {
+			continue
+		}
+		for _, t := range r.Conflicts {
+			for _, other := range w.registry {
+				if other == r || w.disabled[other] || !t.Matches(other) || seen[[2]*Registration{other, r}] {
+					continue
+				}
+				seen[[2]*Registration{r, other}] = true
+				errs = append(errs, fmt.Errorf("%s conflicts with %s: %w", r.URI(), other.URI(), ErrPluginConflict))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		panic(errors.Join(errs...))
+	}
+	return nil
+}
+
+// UnsatisfiedRequirementError is returned when Requires entries of a plugin
+// match no enabled plugin
+type UnsatisfiedRequirementError struct {
+	// Plugin is the URI of the plugin with unsatisfied requirements
+	Plugin string
+	// Missing is the list of Requires entries matching no enabled plugin
+	Missing []Type
+}
+
+func (e *UnsatisfiedRequirementError) Error() string {
+	return fmt.Sprintf("%s requires %v: %v", e.Plugin, e.Missing, ErrUnsatisfiedRequirement)
+}
+
+// Unwrap returns ErrUnsatisfiedRequirement
+func (e *UnsatisfiedRequirementError) Unwrap() error {
+	return ErrUnsatisfiedRequirement
+}
+
+// requirements panics with an UnsatisfiedRequirementError for each enabled
+// registration with Requires entries matching no enabled registration.
+func (w *walker) requirements() {
+	var errs []error
+	for _, r := range w.registry {
+		if w.disabled[r] {
+			continue
+		}
+		var missing []Type
+		for _, t := range r.Requires {
+			if t == "*" {
+				continue
+			}
+			satisfied := false
+			for _, other := range w.registry {
+				if other != r && !w.disabled[other] && w.matches(t, other) {
+					satisfied = true
+					break
+				}
+			}
+			if !satisfied {
+				missing = append(missing, t)
+			}
+		}
+		if len(missing) > 0 {
+			errs = append(errs, &UnsatisfiedRequirementError{Plugin: r.URI(), Missing: missing})
+		}
+	}
+	if len(errs) > 0 {
+		panic(errors.Join(errs...))
+	}
+}
+
+// disableConflicts disables each registration conflicting with an enabled
+// registration which takes precedence over it.
+func (w *walker) disableConflicts() []Conflict {
+	var (
+		conflicts []Conflict
+		kept      []*Registration
+	)
+	for _, r := range w.registry {
+		if w.disabled[r] {
+			continue
+		}
+		var winner *Registration
+		for _, k := range kept {
+			if conflicting(r, k) {
+				winner = k
+				break
+			}
+		}
+		if winner == nil {
+			kept = append(kept, r)
+			continue
+		}
+		w.disabled[r] = true
+		conflicts = append(conflicts, Conflict{Disabled: r.URI(), Kept: winner.URI()})
+	}
+	return conflicts
+}
+
+// conflicting returns true if either registration declares a conflict with
+// the other
+func conflicting(a, b *Registration) bool {
+	for _, t := range a.Conflicts {
+		if t.Matches(b) {
+			return true
+		}
+	}
+	for _, t := range b.Conflicts {
+		if t.Matches(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate computes the graph in the same way as Graph, returning an error
+// rather than panicking when enabled plugins conflict or the dependencies
+// cannot be ordered. Every cycle is reported as a CycleError. With
+// WithStrictRequires, Requires entries matching no enabled plugin are
+// returned as an UnsatisfiedRequirementError.
+func (registry Registry) Validate(filter DisableFilter, opts ...GraphOpt) (err error) {
+	defer recoverGraph(&err)
+	o := newGraphOptions(opts)
+	w, _ := registry.walker(registry.disabled(filter, o), o, func(*Registration) {})
+	if err := w.cycles(); err != nil {
+		return err
+	}
+	for _, r := range w.registry {
+		if !w.disabled[r] {
+			w.add(r)
+		}
+	}
+	return nil
+}
+
+// ValidateAll checks every registration of the registry in a single pass,
+// returning every problem found joined into a single error. Unlike Validate
+// every plugin is enabled and the checks include invalid registrations, such
+// as misplaced wildcards, duplicate URIs, plugins requiring themselves,
+// Requires entries without a registered plugin and every cycle. Conflicts
+// between plugins are not reported since conflicting plugins are expected to
+// be disabled by configuration.
+func (registry Registry) ValidateAll(opts ...GraphOpt) error {
+	var (
+		errs []error
+		seen = map[string]bool{}
+	)
+	for _, r := range registry {
+		if seen[r.URI()] {
+			errs = append(errs, fmt.Errorf("%s: %w", r.URI(), ErrIDRegistered))
+		}
+		seen[r.URI()] = true
+		if err := catch(func() { validate(r) }); err != nil {
+			if !strings.HasPrefix(err.Error(), r.URI()) {
+				err = fmt.Errorf("%s: %w", r.URI(), err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	o := newGraphOptions(opts)
+	w := &walker{
+		registry: registry.prioritized(),
+		disabled: map[*Registration]bool{},
+
+		wildcardExclude: o.wildcardExclude,
+	}
+	errs = append(errs, catch(w.requirements))
+	errs = append(errs, catch(func() {
+		if err := w.cycles(); err != nil {
+			panic(err)
+		}
+	}))
+	return errors.Join(errs...)
+}
+
+// catch returns the error fn panicked with
+func catch(fn func()) (err error) {
+	defer recoverGraph(&err)
+	fn()
+	return nil
+}
+
+// GraphE computes the same ordered list as Graph, returning an error such
+// as ErrPluginCircularDependency rather than panicking when the graph cannot
+// be computed. Use it for registries assembled from user configuration,
+// along with WithStrictRequires to return an UnsatisfiedRequirementError
+// for required types without enabled plugins.
+func (registry Registry) GraphE(filter DisableFilter, opts ...GraphOpt) (ordered []Registration, err error) {
+	defer recoverGraph(&err)
+	return registry.Graph(filter, opts...), nil
+}
+
+// graphErrors are the errors registration and graph computations panic
+// with, errors panicked with are expected to wrap one of them
+var graphErrors = []error{
+	ErrNoType,
+	ErrNoPluginID,
+	ErrIDRegistered,
+	ErrPluginNotFound,
+	ErrPluginMultipleInstances,
+	ErrInvalidRequires,
+	ErrInvalidOptional,
+	ErrInvalidBarrier,
+	ErrInvalidBefore,
+	ErrInvalidOrderHint,
+	ErrPluginCircularDependency,
+	ErrPluginConflict,
+	ErrUnsatisfiedRequirement,
+	ErrCardinality,
+	ErrVersionMismatch,
+}
+
+// isGraphError returns true if err is an error panicked with by this
+// package, as opposed to a failure in caller code such as a DisableFilter
+func isGraphError(err error) bool {
+	var rerr runtime.Error
+	if errors.As(err, &rerr) {
+		return false
+	}
+	for _, e := range graphErrors {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// recoverGraph sets err to the error a graph computation panicked with.
+// Other panics, such as runtime errors in a DisableFilter or EnabledFn, are
+// not recovered.
+func recoverGraph(err *error) {
+	if v := recover(); v != nil {
+		e, ok := v.(error)
+		if !ok || !isGraphError(e) {
+			panic(v)
+		}
+		*err = e
+	}
+}
@@ -0,0 +1,832 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestOrderHints(t *testing.T) {
+	var register Registry
+	register = register.Register(&Registration{
+		Type: "snapshotter",
+		ID:   "overlayfs",
+	}).Register(&Registration{
+		Type: "metadata",
+		ID:   "bolt",
+		Requires: []Type{
+			"snapshotter",
+		},
+	}).Register(&Registration{
+		Type: "snapshotter",
+		ID:   "native",
+	})
+
+	cmpOrdered(t, register.Graph(mockPluginFilter), []string{
+		"snapshotter.overlayfs",
+		"snapshotter.native",
+		"metadata.bolt",
+	})
+	cmpOrdered(t, register.Graph(mockPluginFilter, WithOrderHints(
+		OrderHint{First: "snapshotter.native", Then: "snapshotter.overlayfs"},
+		OrderHint{First: "snapshotter.missing", Then: "snapshotter.native"},
+	)), []string{
+		"snapshotter.native",
+		"snapshotter.overlayfs",
+		"metadata.bolt",
+	})
+
+	defer func() {
+		err, ok := recover().(error)
+		if !ok || !errors.Is(err, ErrInvalidOrderHint) {
+			t.Fatalf("expected invalid order hint panic, got %v", err)
+		}
+	}()
+	register.Graph(mockPluginFilter, WithOrderHints(OrderHint{First: "metadata.bolt", Then: "snapshotter.native"}))
+}
+
+func TestWildcardExclude(t *testing.T) {
+	var register Registry
+	register = register.Register(&Registration{
+		Type:     "grpc",
+		ID:       "introspection",
+		Requires: []Type{"*"},
+	}).Register(&Registration{
+		Type: "internal",
+		ID:   "tracing",
+	}).Register(&Registration{
+		Type: "service",
+		ID:   "containers",
+	})
+
+	cmpOrdered(t, register.Graph(mockPluginFilter), []string{
+		"internal.tracing",
+		"service.containers",
+		"grpc.introspection",
+	})
+	cmpOrdered(t, register.Graph(mockPluginFilter, WithWildcardExclude("internal")), []string{
+		"service.containers",
+		"grpc.introspection",
+		"internal.tracing",
+	})
+}
+
+func TestGraphUpdate(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "snapshotter", ID: "overlayfs"}).
+		Register(&Registration{Type: "snapshotter", ID: "btrfs"}).
+		Register(&Registration{Type: "metadata", ID: "bolt", Requires: []Type{"content", "snapshotter"}}).
+		Register(&Registration{Type: "gc", ID: "scheduler", Requires: []Type{"metadata"}}).
+		Register(&Registration{Type: "diff", ID: "walking"})
+
+	disabled := map[string]bool{"snapshotter.btrfs": true}
+	filter := func(r *Registration) bool { return disabled[r.URI()] }
+	g := registry.Resolve(filter)
+
+	disabled["snapshotter.btrfs"] = false
+	update := registry.Update(g, filter, []string{"snapshotter.btrfs"})
+	cmpOrderedRefs(t, update.Stop, []string{"gc.scheduler", "metadata.bolt"})
+	cmpOrderedRefs(t, update.Start, []string{"snapshotter.btrfs", "metadata.bolt", "gc.scheduler"})
+	cmpOrderedRefs(t, update.Graph.Ordered, []string{"content.local", "snapshotter.overlayfs", "diff.walking", "snapshotter.btrfs", "metadata.bolt", "gc.scheduler"})
+	cmpOrderedRefs(t, update.Graph.Disabled, nil)
+
+	disabled["snapshotter.overlayfs"] = true
+	update = registry.Update(update.Graph, filter, []string{"snapshotter.overlayfs", "diff.walking"})
+	cmpOrderedRefs(t, update.Stop, []string{"gc.scheduler", "metadata.bolt", "snapshotter.overlayfs"})
+	cmpOrderedRefs(t, update.Start, []string{"metadata.bolt", "gc.scheduler"})
+	cmpOrderedRefs(t, update.Graph.Ordered, []string{"content.local", "diff.walking", "snapshotter.btrfs", "metadata.bolt", "gc.scheduler"})
+	cmpOrderedRefs(t, update.Graph.Disabled, []string{"snapshotter.overlayfs"})
+
+	if unchanged := registry.Update(update.Graph, filter, []string{"diff.walking"}); unchanged.Graph != update.Graph || len(unchanged.Stop)+len(unchanged.Start) != 0 {
+		t.Fatalf("expected no actions, got %+v", unchanged)
+	}
+}
+
+func TestGraphUpdateOptions(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "warm", ID: "w"}).
+		Register(&Registration{Type: "svc", ID: "x", Requires: []Type{"warm"}}).
+		Register(&Registration{Type: "monitor", ID: "cgroups", Conflicts: []Type{Instance("monitor", "systemd")}}).
+		Register(&Registration{Type: "monitor", ID: "systemd", Conflicts: []Type{Instance("monitor", "cgroups")}}).
+		Register(&Registration{Type: "content", ID: "local"})
+
+	uris := func(regs []*Registration) []string {
+		var s []string
+		for _, r := range regs {
+			s = append(s, r.URI())
+		}
+		sort.Strings(s)
+		return s
+	}
+	disabled := map[string]bool{"monitor.systemd": true}
+	filter := func(r *Registration) bool { return disabled[r.URI()] }
+	opts := []GraphOpt{WithCascadeDisable(), WithConflictAutoDisable(), WithSortedOrder()}
+	g := registry.Resolve(filter, opts...)
+
+	disabled["warm.w"] = true
+	disabled["monitor.systemd"] = false
+	update := registry.Update(g, filter, []string{"warm.w", "monitor.systemd"}, opts...)
+	expected := registry.Resolve(filter, opts...)
+	if !reflect.DeepEqual(uris(update.Graph.Ordered), uris(expected.Ordered)) {
+		t.Fatalf("unexpected enabled plugins %v, expected %v", uris(update.Graph.Ordered), uris(expected.Ordered))
+	}
+	if !reflect.DeepEqual(uris(update.Graph.Disabled), uris(expected.Disabled)) {
+		t.Fatalf("unexpected disabled plugins %v, expected %v", uris(update.Graph.Disabled), uris(expected.Disabled))
+	}
+	if !reflect.DeepEqual(update.Graph.Cascades, expected.Cascades) || len(update.Graph.Cascades) != 1 {
+		t.Fatalf("unexpected cascades %v, expected %v", update.Graph.Cascades, expected.Cascades)
+	}
+	if !reflect.DeepEqual(update.Graph.Conflicts, expected.Conflicts) || len(update.Graph.Conflicts) != 1 {
+		t.Fatalf("unexpected conflicts %v, expected %v", update.Graph.Conflicts, expected.Conflicts)
+	}
+	if s := uris(update.Stop); !reflect.DeepEqual(s, []string{"svc.x", "warm.w"}) {
+		t.Fatalf("unexpected stopped plugins %v", s)
+	}
+
+	// Re-enabling the required plugin re-enables the cascade
+	disabled["warm.w"] = false
+	update = registry.Update(update.Graph, filter, []string{"warm.w"}, opts...)
+	cmpOrderedRefs(t, update.Start, []string{"warm.w", "svc.x"})
+	if s := uris(update.Graph.Disabled); !reflect.DeepEqual(s, uris(registry.Resolve(filter, opts...).Disabled)) {
+		t.Fatalf("unexpected disabled plugins %v", s)
+	}
+
+	defer func() {
+		if err, ok := recover().(error); !ok || !errors.Is(err, ErrUnsatisfiedRequirement) {
+			t.Fatalf("expected strict requirement panic, got %v", err)
+		}
+	}()
+	disabled["warm.w"] = true
+	registry.Update(registry.Resolve(func(*Registration) bool { return false }, WithConflictAutoDisable()), filter, []string{"warm.w"}, WithConflictAutoDisable(), WithStrictRequires())
+}
+
+func TestConflicts(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "monitor", ID: "cgroups", Conflicts: []Type{Instance("monitor", "systemd")}}).
+		Register(&Registration{Type: "monitor", ID: "systemd", Conflicts: []Type{Instance("monitor", "cgroups")}}).
+		Register(&Registration{Type: "content", ID: "local"})
+
+	err := registry.Validate(mockPluginFilter)
+	if !errors.Is(err, ErrPluginConflict) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+	if expected := "monitor.cgroups conflicts with monitor.systemd: plugin: conflict"; err.Error() != expected {
+		t.Fatalf("expected conflict reported once as %q, got %q", expected, err.Error())
+	}
+
+	noSystemd := func(r *Registration) bool { return r.ID == "systemd" }
+	if err := registry.Validate(noSystemd); err != nil {
+		t.Fatalf("expected no conflict with systemd disabled, got %v", err)
+	}
+	cmpOrderedRefs(t, registry.GraphRefs(noSystemd), []string{"monitor.cgroups", "content.local"})
+
+	defer func() {
+		if err, ok := recover().(error); !ok || !errors.Is(err, ErrPluginConflict) {
+			t.Fatalf("expected Graph to panic with conflict, got %v", err)
+		}
+	}()
+	registry.Graph(mockPluginFilter)
+}
+
+func TestCheckTombstones(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "grpc", ID: "tasks", Requires: []Type{"runtime.v1", "events"}}).
+		Register(&Registration{Type: "cri", ID: "images", Optional: []Type{"snapshotter.v0"}})
+
+	err := registry.CheckTombstones([]Tombstone{
+		{Type: "runtime.v1", Replacement: "runtime.v2", Note: "removed in 2.0"},
+		{Type: "snapshotter.v0"},
+	})
+	if !errors.Is(err, ErrRemovedType) {
+		t.Fatalf("expected removed type error, got %v", err)
+	}
+	expected := "grpc.tasks: requires type runtime.v1 was removed, use runtime.v2 instead (removed in 2.0)\n" +
+		"cri.images: optional type snapshotter.v0 was removed"
+	if err.Error() != expected {
+		t.Fatalf("unexpected error %q", err.Error())
+	}
+	var rerr *RemovedTypeError
+	if !errors.As(err, &rerr) || rerr.Replacement != "runtime.v2" {
+		t.Fatalf("expected replacement in error, got %v", rerr)
+	}
+	if err := registry.CheckTombstones([]Tombstone{{Type: "diff.v0"}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	registry = registry.Register(&Registration{Type: "service", ID: "tasks", Requires: []Type{"runtime.v1.linux", "content >= 1.0"}}).
+		Register(&Registration{Type: "service", ID: "images", Before: []Type{"io.containerd.snapshotter.v1.aufs"}})
+	for _, tc := range []struct {
+		tombstone Tombstone
+		expected  []string
+	}{
+		// A removed type covers the plugin URIs of the type
+		{Tombstone{Type: "runtime.v1"}, []string{"grpc.tasks", "service.tasks"}},
+		// A removed plugin URI
+		{Tombstone{Type: "io.containerd.snapshotter.v1.aufs"}, []string{"service.images"}},
+		// A glob over removed versions
+		{Tombstone{Type: "snapshotter.v[0-9]"}, []string{"cri.images"}},
+		{Tombstone{Type: "io.containerd.snapshotter.*.aufs"}, []string{"service.images"}},
+		// A dependency with a version constraint
+		{Tombstone{Type: "content"}, []string{"service.tasks"}},
+	} {
+		err := registry.CheckTombstones([]Tombstone{tc.tombstone})
+		var plugins []string
+		for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+			if errors.As(e, &rerr) {
+				plugins = append(plugins, rerr.Plugin)
+			}
+		}
+		if !reflect.DeepEqual(plugins, tc.expected) {
+			t.Errorf("%s: unexpected plugins %v, expected %v", tc.tombstone.Type, plugins, tc.expected)
+		}
+	}
+}
+
+func TestVersionConstraints(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "snapshotter", ID: "overlayfs", Version: "v1.2.0"}).
+		Register(&Registration{Type: "snapshotter", ID: "native", Version: "1.4"}).
+		Register(&Registration{Type: "snapshotter", ID: "devmapper"}).
+		Register(&Registration{Type: "service", ID: "snapshots", Requires: []Type{"snapshotter >= 1.2"}}).
+		Register(&Registration{Type: "service", ID: "overlay", Requires: []Type{Instance("snapshotter", "overlayfs") + " < 2"}})
+
+	noDevmapper := func(r *Registration) bool { return r.ID == "devmapper" }
+	cmpOrderedRefs(t, registry.GraphRefs(noDevmapper), []string{
+		"snapshotter.overlayfs",
+		"snapshotter.native",
+		"service.snapshots",
+		"service.overlay",
+	})
+
+	err := registry.Validate(mockPluginFilter)
+	var verr *VersionError
+	if !errors.As(err, &verr) || !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("expected version error, got %v", err)
+	}
+	if verr.Plugin != "service.snapshots" || verr.Dependency != "snapshotter.devmapper" {
+		t.Fatalf("unexpected version error %v", verr)
+	}
+
+	strict := registry.Register(&Registration{Type: "service", ID: "native", Requires: []Type{Instance("snapshotter", "native") + " >= 2.0.0"}})
+	if err := strict.Validate(noDevmapper); !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("expected version mismatch, got %v", err)
+	}
+}
+
+func TestRequiresPattern(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "io.containerd.grpc.v1", ID: "snapshots", Requires: []Type{"io.containerd.snapshotter.*"}}).
+		Register(&Registration{Type: "io.containerd.snapshotter.v1", ID: "overlayfs"}).
+		Register(&Registration{Type: "io.containerd.content.v1", ID: "content"}).
+		Register(&Registration{Type: "io.containerd.snapshotter.v2", ID: "erofs"})
+
+	cmpOrderedRefs(t, registry.GraphRefs(mockPluginFilter), []string{
+		"io.containerd.snapshotter.v1.overlayfs",
+		"io.containerd.snapshotter.v2.erofs",
+		"io.containerd.grpc.v1.snapshots",
+		"io.containerd.content.v1.content",
+	})
+
+	for _, r := range []*Registration{
+		{Type: "service", ID: "requires", Requires: []Type{"snapshotter.[v"}},
+		{Type: "service", ID: "barrier", Barrier: []Type{"snapshotter.[v"}},
+	} {
+		func() {
+			defer func() {
+				if err, ok := recover().(error); !ok || !(errors.Is(err, ErrInvalidRequires) || errors.Is(err, ErrInvalidBarrier)) {
+					t.Fatalf("expected invalid pattern panic for %s, got %v", r.URI(), err)
+				}
+			}()
+			registry.Register(r)
+		}()
+	}
+}
+
+func TestEnabledFn(t *testing.T) {
+	type cgroupKey struct{}
+	cgroupV2 := func(ctx context.Context) bool {
+		v, _ := ctx.Value(cgroupKey{}).(int)
+		return v == 2
+	}
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "monitor", ID: "cgroups", EnabledFn: cgroupV2}).
+		Register(&Registration{Type: "grpc", ID: "tasks", Optional: []Type{"monitor"}})
+
+	g := registry.Resolve(mockPluginFilter)
+	cmpOrderedRefs(t, g.Ordered, []string{"content.local", "grpc.tasks"})
+	cmpOrderedRefs(t, g.Disabled, []string{"monitor.cgroups"})
+
+	ctx := context.WithValue(context.Background(), cgroupKey{}, 2)
+	cmpOrderedRefs(t, registry.GraphRefs(mockPluginFilter, WithContext(ctx)), []string{"content.local", "monitor.cgroups", "grpc.tasks"})
+}
+
+func TestCardinality(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "snapshotter", ID: "overlayfs"}).
+		Register(&Registration{Type: "snapshotter", ID: "native"}).
+		Register(&Registration{Type: "metadata", ID: "bolt"}).
+		Register(&Registration{Type: "metadata", ID: "sqlite"}).
+		Register(&Registration{
+			Type:     "service",
+			ID:       "snapshots",
+			Requires: []Type{"snapshotter", "metadata"},
+			Cardinality: map[Type]Cardinality{
+				"snapshotter": AtLeast(1),
+				"metadata":    Exactly(1),
+			},
+		})
+
+	noSqlite := func(r *Registration) bool { return r.ID == "sqlite" }
+	if err := registry.Validate(noSqlite); err != nil {
+		t.Fatal(err)
+	}
+
+	var cerr *CardinalityError
+	err := registry.Validate(mockPluginFilter)
+	if !errors.As(err, &cerr) || !errors.Is(err, ErrCardinality) || cerr.Requires != "metadata" || cerr.Count != 2 {
+		t.Fatalf("expected metadata cardinality error, got %v", err)
+	}
+	err = registry.Validate(func(r *Registration) bool { return r.Type == "snapshotter" || r.ID == "sqlite" })
+	if !errors.As(err, &cerr) || cerr.Requires != "snapshotter" || cerr.Count != 0 {
+		t.Fatalf("expected snapshotter cardinality error, got %v", err)
+	}
+
+	defer func() {
+		if err, ok := recover().(error); !ok || !errors.Is(err, ErrInvalidRequires) {
+			t.Fatalf("expected invalid requires panic, got %v", err)
+		}
+	}()
+	registry.Register(&Registration{Type: "service", ID: "content", Cardinality: map[Type]Cardinality{"content": Exactly(1)}})
+}
+
+func TestStrictRequires(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "metadata", ID: "bolt", Requires: []Type{"content", "snapshotter"}}).
+		Register(&Registration{Type: "grpc", ID: "introspection", Requires: []Type{"*"}})
+
+	if err := registry.Validate(mockPluginFilter); err != nil {
+		t.Fatalf("expected unsatisfied requirements to be ignored, got %v", err)
+	}
+	err := registry.Validate(mockPluginFilter, WithStrictRequires())
+	var uerr *UnsatisfiedRequirementError
+	if !errors.As(err, &uerr) || !errors.Is(err, ErrUnsatisfiedRequirement) {
+		t.Fatalf("expected unsatisfied requirement, got %v", err)
+	}
+	if uerr.Plugin != "metadata.bolt" || len(uerr.Missing) != 1 || uerr.Missing[0] != "snapshotter" {
+		t.Fatalf("unexpected error %v", uerr)
+	}
+
+	noContent := func(r *Registration) bool { return r.Type == "content" }
+	err = registry.Register(&Registration{Type: "snapshotter", ID: "native"}).Validate(noContent, WithStrictRequires())
+	if !errors.As(err, &uerr) || fmt.Sprint(uerr.Missing) != "[content]" {
+		t.Fatalf("expected disabled content to be missing, got %v", err)
+	}
+
+	missing := registry.Register(&Registration{Type: "service", ID: "images", Requires: []Type{"metadata", "differ", "transfer"}})
+	ordered, err := missing.GraphE(mockPluginFilter, WithStrictRequires())
+	if ordered != nil || !errors.Is(err, ErrUnsatisfiedRequirement) {
+		t.Fatalf("expected unsatisfied requirement, got %v", err)
+	}
+	for _, expected := range []string{"metadata.bolt requires [snapshotter]", "service.images requires [differ transfer]"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %v", expected, err)
+		}
+	}
+}
+
+func TestGraphECallerPanic(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "content", ID: "local"})
+
+	for _, tc := range []struct {
+		name   string
+		filter DisableFilter
+	}{
+		{
+			name: "runtime error",
+			filter: func(r *Registration) bool {
+				var seen map[string]bool
+				seen[r.URI()] = true
+				return false
+			},
+		},
+		{
+			name:   "caller error",
+			filter: func(*Registration) bool { panic(errors.New("filter failed")) },
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("expected caller panic to propagate")
+				}
+			}()
+			_, err := registry.GraphE(tc.filter)
+			t.Fatalf("expected panic, got %v", err)
+		})
+	}
+}
+
+func TestSameTypeRequires(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "snapshotter", ID: "cache", Requires: []Type{Instance("snapshotter", "overlayfs")}}).
+		Register(&Registration{Type: "snapshotter", ID: "overlayfs"})
+	cmpOrderedRefs(t, registry.GraphRefs(mockPluginFilter), []string{"snapshotter.overlayfs", "snapshotter.cache"})
+
+	cyclic := registry.Replace(&Registration{Type: "snapshotter", ID: "overlayfs", Requires: []Type{Instance("snapshotter", "cache")}})
+	if err := cyclic.Validate(mockPluginFilter); !errors.Is(err, ErrPluginCircularDependency) {
+		t.Fatalf("expected circular dependency, got %v", err)
+	}
+	if ordered, err := cyclic.GraphE(mockPluginFilter); !errors.Is(err, ErrPluginCircularDependency) || ordered != nil {
+		t.Fatalf("expected circular dependency, got %v, %v", ordered, err)
+	}
+	if ordered, err := registry.GraphE(mockPluginFilter); err != nil || len(ordered) != 2 {
+		t.Fatalf("unexpected graph %v, %v", ordered, err)
+	}
+
+	defer func() {
+		if err, ok := recover().(error); !ok || !errors.Is(err, ErrInvalidRequires) {
+			t.Fatalf("expected invalid requires panic, got %v", err)
+		}
+	}()
+	registry.Register(&Registration{Type: "snapshotter", ID: "native", Requires: []Type{Instance("snapshotter", "native")}})
+}
+
+func TestExplain(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "grpc", ID: "introspection", Requires: []Type{"*"}}).
+		Register(&Registration{Type: "metadata", ID: "bolt", Requires: []Type{"content"}}).
+		Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "gc", ID: "scheduler"})
+
+	explanations := registry.Explain(mockPluginFilter)
+	var order []string
+	for _, e := range explanations {
+		order = append(order, e.Plugin)
+	}
+	if fmt.Sprint(order) != "[content.local metadata.bolt gc.scheduler grpc.introspection]" {
+		t.Fatalf("unexpected order %v", order)
+	}
+	if e := explanations[0]; e.PulledBy != "grpc.introspection" || len(e.Edges) != 0 {
+		t.Fatalf("unexpected explanation %+v", e)
+	}
+	if e := explanations[1]; e.PulledBy != "grpc.introspection" || len(e.Edges) != 1 || e.Edges[0] != (Edge{Dependency: "content.local", Kind: EdgeRequires, Entry: "content"}) {
+		t.Fatalf("unexpected explanation %+v", e)
+	}
+	if e := explanations[3]; !e.Tiebreak() || len(e.Edges) != 3 || !e.Edges[0].Wildcard || e.Position != 3 {
+		t.Fatalf("unexpected explanation %+v", e)
+	}
+}
+
+func TestDependents(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "metadata", ID: "bolt", Requires: []Type{"content"}}).
+		Register(&Registration{Type: "gc", ID: "scheduler", Requires: []Type{"metadata"}}).
+		Register(&Registration{Type: "snapshotter", ID: "overlayfs"}).
+		Register(&Registration{Type: "grpc", ID: "introspection", Requires: []Type{"*"}})
+
+	cmpOrderedRefs(t, registry.Dependents("metadata", "bolt"), []string{"gc.scheduler", "grpc.introspection"})
+	cmpOrderedRefs(t, registry.Dependents("snapshotter", "overlayfs"), []string{"grpc.introspection"})
+	if dependents := registry.Dependents("grpc", "introspection"); len(dependents) != 0 {
+		t.Fatalf("expected no dependents, got %d", len(dependents))
+	}
+	if dependents := registry.Dependents("content", "missing"); dependents != nil {
+		t.Fatalf("expected no dependents for a missing plugin, got %d", len(dependents))
+	}
+
+	// barriers and ordering constraints do not make a plugin a dependent
+	registry = nil
+	registry = registry.Register(&Registration{Type: "warm", ID: "w"}).
+		Register(&Registration{Type: "svc", ID: "x", Barrier: []Type{"warm"}, InitAfter: []Type{"warm"}}).
+		Register(&Registration{Type: "svc", ID: "y", Before: []Type{"warm"}}).
+		Register(&Registration{Type: "svc", ID: "z", ConfigFor: []Type{"warm"}}).
+		Register(&Registration{Type: "svc", ID: "opt", Optional: []Type{"warm"}}).
+		Register(&Registration{Type: "svc", ID: "needs", Needs: []string{"cache"}}).
+		Register(&Registration{Type: "cache", ID: "c", Requires: []Type{"warm"}, Provides: []string{"cache"}})
+
+	cmpOrderedRefs(t, registry.Dependents("warm", "w"), []string{"svc.opt", "svc.needs", "cache.c"})
+	if dependents := registry.Dependents("svc", "y"); len(dependents) != 0 {
+		t.Fatalf("expected no dependents of a Before declarer, got %d", len(dependents))
+	}
+}
+
+func TestSubgraph(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "grpc", ID: "content", Requires: []Type{"content"}}).
+		Register(&Registration{Type: "metadata", ID: "bolt", Requires: []Type{"content"}}).
+		Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "snapshotter", ID: "overlayfs"}).
+		Register(&Registration{Type: "gc", ID: "scheduler", Requires: []Type{"metadata"}})
+
+	cmpOrdered(t, registry.Subgraph("metadata"), []string{"content.local", "metadata.bolt"})
+	cmpOrdered(t, registry.Subgraph(Instance("gc", "scheduler"), "grpc"), []string{"content.local", "grpc.content", "metadata.bolt", "gc.scheduler"})
+	if ordered := registry.Subgraph("differ"); len(ordered) != 0 {
+		t.Fatalf("expected empty subgraph, got %d", len(ordered))
+	}
+
+	// barriers and ordering constraints order the subgraph without
+	// pulling plugins into it
+	registry = nil
+	registry = registry.Register(&Registration{Type: "warm", ID: "w"}).
+		Register(&Registration{Type: "hint", ID: "h"}).
+		Register(&Registration{Type: "early", ID: "e", Before: []Type{"svc"}}).
+		Register(&Registration{Type: "cache", ID: "c", Provides: []string{"cache"}, Before: []Type{"store"}}).
+		Register(&Registration{Type: "svc", ID: "x", Requires: []Type{"store"}, Needs: []string{"cache"}, Barrier: []Type{"warm"}, InitAfter: []Type{"hint"}}).
+		Register(&Registration{Type: "store", ID: "s"})
+
+	cmpOrdered(t, registry.Subgraph("svc"), []string{"cache.c", "store.s", "svc.x"})
+}
+
+func TestStages(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "snapshotter", ID: "overlayfs"}).
+		Register(&Registration{Type: "metadata", ID: "bolt", Requires: []Type{"content", "snapshotter"}}).
+		Register(&Registration{Type: "snapshotter", ID: "native"}).
+		Register(&Registration{Type: "gc", ID: "scheduler", Requires: []Type{"metadata"}}).
+		Register(&Registration{Type: "diff", ID: "walking", Requires: []Type{"content"}})
+
+	stages := registry.Stages(func(r *Registration) bool { return r.ID == "native" })
+	var levels []string
+	for _, stage := range stages {
+		var uris []string
+		for _, r := range stage {
+			uris = append(uris, r.URI())
+		}
+		levels = append(levels, strings.Join(uris, ","))
+	}
+	if fmt.Sprint(levels) != "[content.local,snapshotter.overlayfs metadata.bolt,diff.walking gc.scheduler]" {
+		t.Fatalf("unexpected stages %v", levels)
+	}
+}
+
+func TestCyclePaths(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "a", ID: "a", Requires: []Type{"b"}}).
+		Register(&Registration{Type: "b", ID: "b", Requires: []Type{"c"}}).
+		Register(&Registration{Type: "c", ID: "c", Requires: []Type{"a"}}).
+		Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "x", ID: "x", Requires: []Type{"content", "y"}}).
+		Register(&Registration{Type: "y", ID: "y", Requires: []Type{"x"}})
+
+	func() {
+		defer func() {
+			var cerr *CycleError
+			if err, ok := recover().(error); !ok || !errors.As(err, &cerr) || err.Error() != "a.a -> b.b -> c.c -> a.a: plugin: circular dependency" {
+				t.Fatalf("expected cycle path, got %v", err)
+			}
+		}()
+		registry.Graph(mockPluginFilter)
+	}()
+
+	err := registry.Validate(mockPluginFilter)
+	for _, expected := range []string{"a.a -> b.b -> c.c -> a.a", "x.x -> y.y -> x.x"} {
+		if !errors.Is(err, ErrPluginCircularDependency) || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected cycle %s, got %v", expected, err)
+		}
+	}
+}
+
+func TestEnableOnly(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "grpc", ID: "content", Requires: []Type{"content"}}).
+		Register(&Registration{Type: "metadata", ID: "bolt", Requires: []Type{"content"}}).
+		Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "snapshotter", ID: "overlayfs"}).
+		Register(&Registration{Type: "gc", ID: "scheduler", Requires: []Type{"metadata"}})
+
+	g := registry.Resolve(registry.EnableOnly("grpc.content", "metadata"))
+	cmpOrderedRefs(t, g.Ordered, []string{"content.local", "grpc.content", "metadata.bolt"})
+	cmpOrderedRefs(t, g.Disabled, []string{"snapshotter.overlayfs", "gc.scheduler"})
+
+	// plugins only ordered before a target by a soft hint stay disabled
+	registry = nil
+	registry = registry.Register(&Registration{Type: "early", ID: "before", Before: []Type{Instance("svc", "x")}}).
+		Register(&Registration{Type: "early", ID: "hint", InitBefore: []Type{"svc"}}).
+		Register(&Registration{Type: "warm", ID: "w"}).
+		Register(&Registration{Type: "store", ID: "s"}).
+		Register(&Registration{Type: "svc", ID: "x", Requires: []Type{"store"}, Barrier: []Type{"warm"}})
+
+	g = registry.Resolve(registry.EnableOnly("svc.x"))
+	cmpOrderedRefs(t, g.Ordered, []string{"store.s", "svc.x"})
+	cmpOrderedRefs(t, g.Disabled, []string{"early.before", "early.hint", "warm.w"})
+}
+
+func TestCascadeDisable(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "metadata", ID: "bolt", Requires: []Type{"content"}, Provides: []string{"leases"}}).
+		Register(&Registration{Type: "gc", ID: "scheduler", Requires: []Type{"metadata"}}).
+		Register(&Registration{Type: "grpc", ID: "leases", Needs: []string{"leases"}}).
+		Register(&Registration{Type: "grpc", ID: "introspection", Requires: []Type{"*"}}).
+		Register(&Registration{Type: "grpc", ID: "gc", Requires: []Type{Instance("gc", "scheduler")}, Optional: []Type{"metadata"}})
+
+	noBolt := func(r *Registration) bool { return r.URI() == "metadata.bolt" }
+	cmpOrderedRefs(t, registry.GraphRefs(noBolt), []string{"content.local", "gc.scheduler", "grpc.leases", "grpc.gc", "grpc.introspection"})
+
+	g := registry.Resolve(noBolt, WithCascadeDisable())
+	cmpOrderedRefs(t, g.Ordered, []string{"content.local", "grpc.introspection"})
+	cmpOrderedRefs(t, g.Disabled, []string{"metadata.bolt", "gc.scheduler", "grpc.leases", "grpc.gc"})
+	var chains []string
+	for _, c := range g.Cascades {
+		chains = append(chains, strings.Join(c.Chain, " -> "))
+	}
+	if fmt.Sprint(chains) != "[metadata.bolt -> gc.scheduler metadata.bolt -> grpc.leases metadata.bolt -> gc.scheduler -> grpc.gc]" {
+		t.Fatalf("unexpected cascades %v", chains)
+	}
+}
+
+func TestDependencyGraph(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "snapshotter", ID: "overlayfs"}).
+		Register(&Registration{Type: "metadata", ID: "bolt", Requires: []Type{"content", "snapshotter"}}).
+		Register(&Registration{Type: "gc", ID: "scheduler", Optional: []Type{"metadata"}}).
+		Register(&Registration{Type: "snapshotter", ID: "native"})
+
+	g := registry.DependencyGraph(func(r *Registration) bool { return r.ID == "native" })
+	cmpOrderedRefs(t, g.Nodes, []string{"content.local", "snapshotter.overlayfs", "metadata.bolt", "gc.scheduler"})
+	cmpOrderedRefs(t, g.Roots, []string{"content.local", "snapshotter.overlayfs"})
+	cmpOrderedRefs(t, g.Leaves, []string{"gc.scheduler"})
+
+	edges := g.Dependencies("metadata.bolt")
+	if len(edges) != 2 || edges[1] != (GraphEdge{Plugin: "metadata.bolt", Edge: Edge{Dependency: "snapshotter.overlayfs", Kind: EdgeRequires, Entry: "snapshotter"}}) {
+		t.Fatalf("unexpected edges %v", edges)
+	}
+	if edges := g.Dependents("metadata.bolt"); len(edges) != 1 || edges[0].Plugin != "gc.scheduler" || edges[0].Kind != EdgeOptional {
+		t.Fatalf("unexpected dependents %v", edges)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	var old Registry
+	old = old.Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "snapshotter", ID: "overlayfs"}).
+		Register(&Registration{Type: "metadata", ID: "bolt", Requires: []Type{"content", "snapshotter"}}).
+		Register(&Registration{Type: "snapshotter", ID: "aufs"})
+
+	var updated Registry
+	updated = updated.Register(&Registration{Type: "snapshotter", ID: "overlayfs"}).
+		Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "metadata", ID: "bolt", Requires: []Type{"content"}}).
+		Register(&Registration{Type: "snapshotter", ID: "erofs"})
+
+	if d := Diff(old, old); !d.Empty() {
+		t.Fatalf("expected no difference, got %+v", d)
+	}
+	d := Diff(old, updated)
+	if fmt.Sprint(d.Added, d.Removed, d.Reordered) != "[snapshotter.erofs] [snapshotter.aufs] [content.local]" {
+		t.Fatalf("unexpected plugin changes %v %v %v", d.Added, d.Removed, d.Reordered)
+	}
+	if len(d.AddedEdges) != 0 || len(d.RemovedEdges) != 2 || d.RemovedEdges[0].Dependency != "snapshotter.overlayfs" || d.RemovedEdges[1].Dependency != "snapshotter.aufs" {
+		t.Fatalf("unexpected edge changes %v %v", d.AddedEdges, d.RemovedEdges)
+	}
+
+	// adding a plugin near the start or moving a single plugin does not
+	// shift the position of the others
+	var chain Registry
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		chain = chain.Register(&Registration{Type: "plugin", ID: id})
+	}
+	var added Registry
+	added = added.Register(chain[0]).Register(&Registration{Type: "plugin", ID: "new"})
+	for _, r := range chain[1:] {
+		added = added.Register(r)
+	}
+	d = Diff(chain, added)
+	if fmt.Sprint(d.Added, d.Removed, d.Reordered) != "[plugin.new] [] []" {
+		t.Fatalf("unexpected plugin changes %v %v %v", d.Added, d.Removed, d.Reordered)
+	}
+	moved := Registry{chain[0], chain[4], chain[1], chain[2], chain[3]}
+	d = Diff(chain, moved)
+	if fmt.Sprint(d.Added, d.Removed, d.Reordered) != "[] [] [plugin.e]" {
+		t.Fatalf("unexpected plugin changes %v %v %v", d.Added, d.Removed, d.Reordered)
+	}
+}
+
+func TestValidateAll(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "metadata", ID: "bolt", Requires: []Type{"content"}})
+	if err := registry.ValidateAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	// registrations assembled without Register are not validated
+	registry = append(registry,
+		&Registration{Type: "content", ID: "local"},
+		&Registration{Type: "grpc", ID: "introspection", Requires: []Type{"content", "*"}},
+		&Registration{Type: "snapshotter", ID: "native", Requires: []Type{Instance("snapshotter", "native")}},
+		&Registration{Type: "gc", ID: "scheduler", Requires: []Type{"differ"}},
+		&Registration{Type: "a", ID: "a", Requires: []Type{"b"}},
+		&Registration{Type: "b", ID: "b", Requires: []Type{"a"}},
+	)
+	err := registry.ValidateAll()
+	for _, expected := range []error{ErrIDRegistered, ErrInvalidRequires, ErrUnsatisfiedRequirement, ErrPluginCircularDependency} {
+		if !errors.Is(err, expected) {
+			t.Errorf("expected %v in %v", expected, err)
+		}
+	}
+	for _, expected := range []string{"content.local: plugin: id already registered", "grpc.introspection: invalid requires", "snapshotter.native: requires itself", "gc.scheduler requires [differ]", "a.a -> b.b -> a.a"} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %v", expected, err)
+		}
+	}
+}
+
+func TestSortedOrder(t *testing.T) {
+	regs := []*Registration{
+		{Type: "snapshotter", ID: "overlayfs"},
+		{Type: "metadata", ID: "bolt", Requires: []Type{"snapshotter", "content"}},
+		{Type: "content", ID: "local"},
+		{Type: "snapshotter", ID: "btrfs"},
+		{Type: "gc", ID: "scheduler", Priority: 1},
+	}
+	var forward, reverse Registry
+	for i := range regs {
+		forward = forward.Register(regs[i])
+		reverse = reverse.Register(regs[len(regs)-1-i])
+	}
+	expected := []string{"gc.scheduler", "content.local", "snapshotter.btrfs", "snapshotter.overlayfs", "metadata.bolt"}
+	cmpOrderedRefs(t, forward.GraphRefs(mockPluginFilter, WithSortedOrder()), expected)
+	cmpOrderedRefs(t, reverse.GraphRefs(mockPluginFilter, WithSortedOrder()), expected)
+}
+
+func TestSoftOrdering(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "metadata", ID: "bolt", Requires: []Type{"content"}}).
+		Register(&Registration{Type: "tracing", ID: "otlp", InitBefore: []Type{"*"}}).
+		Register(&Registration{Type: "gc", ID: "scheduler", InitAfter: []Type{"grpc", "metadata"}}).
+		Register(&Registration{Type: "grpc", ID: "tasks"})
+
+	cmpOrderedRefs(t, registry.GraphRefs(mockPluginFilter), []string{"tracing.otlp", "content.local", "metadata.bolt", "grpc.tasks", "gc.scheduler"})
+
+	// absent plugins are ignored and contradicting hints are dropped
+	cmpOrderedRefs(t, registry.GraphRefs(func(r *Registration) bool { return r.Type == "grpc" }), []string{"tracing.otlp", "content.local", "metadata.bolt", "gc.scheduler"})
+	contradicting := registry.Register(&Registration{Type: "exporter", ID: "otlp", InitAfter: []Type{"tracing"}, Before: []Type{"tracing"}})
+	cmpOrderedRefs(t, contradicting.GraphRefs(mockPluginFilter), []string{"exporter.otlp", "tracing.otlp", "content.local", "metadata.bolt", "grpc.tasks", "gc.scheduler"})
+}
+
+func TestConflictAutoDisable(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "nri", ID: "cpu-pinning", Conflicts: []Type{"nri"}}).
+		Register(&Registration{Type: "nri", ID: "topology"}).
+		Register(&Registration{Type: "nri", ID: "balloons", Conflicts: []Type{Instance("nri", "cpu-pinning")}}).
+		Register(&Registration{Type: "content", ID: "local"})
+
+	g := registry.Resolve(mockPluginFilter, WithConflictAutoDisable())
+	cmpOrderedRefs(t, g.Ordered, []string{"nri.cpu-pinning", "content.local"})
+	cmpOrderedRefs(t, g.Disabled, []string{"nri.topology", "nri.balloons"})
+	if len(g.Conflicts) != 2 || g.Conflicts[0] != (Conflict{Disabled: "nri.topology", Kept: "nri.cpu-pinning"}) {
+		t.Fatalf("unexpected conflicts %+v", g.Conflicts)
+	}
+
+	noPinning := func(r *Registration) bool { return r.ID == "cpu-pinning" }
+	g = registry.Resolve(noPinning, WithConflictAutoDisable())
+	cmpOrderedRefs(t, g.Ordered, []string{"nri.topology", "nri.balloons", "content.local"})
+	if len(g.Conflicts) != 0 {
+		t.Fatalf("unexpected conflicts %+v", g.Conflicts)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "snapshotter", ID: "native"}).
+		Register(&Registration{Type: "snapshotter", ID: "overlayfs", Priority: 10}).
+		Register(&Registration{Type: "snapshotter", ID: "btrfs"}).
+		Register(&Registration{Type: "content", ID: "local", Priority: -1}).
+		Register(&Registration{Type: "snapshotter", ID: "devmapper", Priority: 10, Requires: []Type{"content"}})
+
+	cmpOrderedRefs(t, registry.GraphRefs(mockPluginFilter), []string{
+		"snapshotter.overlayfs",
+		"content.local",
+		"snapshotter.devmapper",
+		"snapshotter.native",
+		"snapshotter.btrfs",
+	})
+}
@@ -0,0 +1,148 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import "sort"
+
+// index buckets the registrations of a walker by the values constraints
+// match on, so the edges of a registration are found without scanning the
+// registry for every constraint.
+type index struct {
+	pos  map[*Registration]int
+	keys map[*Registration][]Type
+	// byKey holds registrations by type, URI and alias URIs
+	byKey map[Type][]*Registration
+	// providers holds registrations by provided capability
+	providers map[string][]*Registration
+	// before holds registrations by their Before and ConfigFor entries
+	before map[Type][]*Registration
+	// scanBefore holds registrations with "*" or pattern Before or
+	// ConfigFor entries, which must be checked against every registration
+	scanBefore []*Registration
+	// candidates caches the result of beforeCandidates by registration
+	candidates map[*Registration][]*Registration
+}
+
+func newIndex(registry []*Registration) *index {
+	x := &index{
+		pos:       make(map[*Registration]int, len(registry)),
+		keys:      make(map[*Registration][]Type, len(registry)),
+		byKey:     make(map[Type][]*Registration, len(registry)),
+		providers: map[string][]*Registration{},
+		before:    map[Type][]*Registration{},
+
+		candidates: make(map[*Registration][]*Registration, len(registry)),
+	}
+	for i, r := range registry {
+		x.pos[r] = i
+		x.keys[r] = keys(r)
+		for _, k := range x.keys[r] {
+			x.byKey[k] = append(x.byKey[k], r)
+		}
+		for _, c := range r.Provides {
+			x.providers[c] = append(x.providers[c], r)
+		}
+		scan := false
+		seen := map[Type]bool{}
+		for _, types := range [][]Type{r.Before, r.ConfigFor} {
+			for _, t := range types {
+				t, _, _ = t.constraint()
+				if t == "*" || t.isPattern() {
+					scan = true
+				} else if !seen[t] {
+					seen[t] = true
+					x.before[t] = append(x.before[t], r)
+				}
+			}
+		}
+		if scan {
+			x.scanBefore = append(x.scanBefore, r)
+		}
+	}
+	return x
+}
+
+// keys returns the values a constraint other than "*" or a pattern may equal
+// to match the registration
+func keys(r *Registration) []Type {
+	k := []Type{r.Type, Type(r.URI())}
+	for _, a := range r.Aliases {
+		for _, alias := range []Type{Type(a), Instance(r.Type, a)} {
+			if !containsType(k, alias) {
+				k = append(k, alias)
+			}
+		}
+	}
+	return k
+}
+
+// matching returns the registrations matching the constraint in registry
+// order, returning false if the constraint must be checked against every
+// registration.
+func (x *index) matching(t Type) ([]*Registration, bool) {
+	t, _, _ = t.constraint()
+	if t == "*" || t.isPattern() {
+		return nil, false
+	}
+	return x.byKey[t], true
+}
+
+// beforeCandidates returns the registrations which may need to be
+// initialized before reg in registry order. The list is computed once per
+// registration as the edges of a registration are visited repeatedly.
+func (x *index) beforeCandidates(reg *Registration) []*Registration {
+	if candidates, ok := x.candidates[reg]; ok {
+		return candidates
+	}
+	lists := [][]*Registration{x.scanBefore}
+	for _, k := range x.keys[reg] {
+		lists = append(lists, x.before[k])
+	}
+	candidates := x.merge(lists)
+	x.candidates[reg] = candidates
+	return candidates
+}
+
+// merge returns the registrations of the lists in registry order without
+// duplicates
+func (x *index) merge(lists [][]*Registration) []*Registration {
+	var (
+		merged  []*Registration
+		nonzero int
+	)
+	for _, l := range lists {
+		if len(l) > 0 {
+			nonzero++
+			merged = l
+		}
+	}
+	if nonzero <= 1 {
+		return merged
+	}
+	seen := map[*Registration]bool{}
+	merged = nil
+	for _, l := range lists {
+		for _, r := range l {
+			if !seen[r] {
+				seen[r] = true
+				merged = append(merged, r)
+			}
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return x.pos[merged[i]] < x.pos[merged[j]] })
+	return merged
+}
@@ -0,0 +1,104 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+type labelRequirement struct {
+	key   string
+	value string
+	op    string // "exists", "!exists", "=" or "!="
+}
+
+// LabelSelector selects registrations by their labels
+type LabelSelector []labelRequirement
+
+// ParseLabelSelector parses a comma separated list of requirements which
+// must all be met by the labels of a registration. A requirement is either
+// "key" or "!key" for the presence of a label, or "key=value" or
+// "key!=value" for its value.
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	var selector LabelSelector
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		var req labelRequirement
+		if k, v, ok := strings.Cut(part, "!="); ok {
+			req = labelRequirement{key: strings.TrimSpace(k), value: strings.TrimSpace(v), op: "!="}
+		} else if k, v, ok := strings.Cut(part, "="); ok {
+			req = labelRequirement{key: strings.TrimSpace(k), value: strings.TrimSpace(v), op: "="}
+		} else if k, ok := strings.CutPrefix(part, "!"); ok {
+			req = labelRequirement{key: strings.TrimSpace(k), op: "!exists"}
+		} else {
+			req = labelRequirement{key: part, op: "exists"}
+		}
+		if req.key == "" || strings.ContainsAny(req.key, "=! ") {
+			return nil, fmt.Errorf("invalid label selector %q", s)
+		}
+		selector = append(selector, req)
+	}
+	return selector, nil
+}
+
+// Matches returns true if the registration meets all requirements
+func (s LabelSelector) Matches(r *Registration) bool {
+	for _, req := range s {
+		v, ok := r.Labels[req.key]
+		switch req.op {
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "!exists":
+			if ok {
+				return false
+			}
+		case "=":
+			if !ok || v != req.value {
+				return false
+			}
+		case "!=":
+			if ok && v == req.value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// DisableLabels returns a filter disabling the registrations matching any of
+// the label selectors, such as "experimental" or "tier=storage,!required".
+func DisableLabels(selectors ...string) (DisableFilter, error) {
+	parsed := make([]LabelSelector, 0, len(selectors))
+	for _, s := range selectors {
+		selector, err := ParseLabelSelector(s)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, selector)
+	}
+	return func(r *Registration) bool {
+		for _, s := range parsed {
+			if s.Matches(r) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
@@ -0,0 +1,318 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// State is the runtime state of a plugin in a Set
+type State int
+
+const (
+	// StateActive is a plugin which initialized successfully
+	StateActive State = iota
+	// StateSkipped is a plugin which was skipped during initialization
+	StateSkipped
+	// StateFailed is a plugin which failed to initialize or failed at runtime
+	StateFailed
+	// StateDegraded is a plugin which is running but depends on a failed plugin
+	StateDegraded
+	// StateStopped is a plugin which was stopped after initialization
+	StateStopped
+)
+
+func (s State) String() string {
+	switch s {
+	case StateActive:
+		return "active"
+	case StateSkipped:
+		return "skipped"
+	case StateFailed:
+		return "failed"
+	case StateDegraded:
+		return "degraded"
+	case StateStopped:
+		return "stopped"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// CascadePolicy determines how the dependents of a plugin which fails at
+// runtime are handled.
+type CascadePolicy int
+
+const (
+	// CascadeNone leaves dependents untouched
+	CascadeNone CascadePolicy = iota
+	// CascadeDegrade marks active dependents as degraded
+	CascadeDegrade
+	// CascadeStop stops dependents, closing instances implementing io.Closer
+	CascadeStop
+)
+
+// Event is emitted when a plugin in a Set changes state after initialization
+type Event struct {
+	// Plugin is the URI of the plugin which changed state
+	Plugin string
+	// State is the new state of the plugin
+	State State
+	// Err is the error which caused the transition, if any
+	Err error
+	// Cause is the URI of the failed plugin when the transition was
+	// cascaded from a dependency, empty otherwise
+	Cause string
+}
+
+// WithCascadePolicy sets the policy applied to dependents of plugins which
+// fail at runtime.
+func WithCascadePolicy(policy CascadePolicy) SetOpt {
+	return func(ps *Set) {
+		ps.cascade = policy
+	}
+}
+
+// WithEventHandler adds a handler called for every runtime state transition.
+// Handlers are called synchronously after the transition has been applied.
+func WithEventHandler(fn func(Event)) SetOpt {
+	return func(ps *Set) {
+		ps.handlers = append(ps.handlers, fn)
+	}
+}
+
+// State returns the current state of the plugin
+func (p *Plugin) State() State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.transitioned {
+		return p.state
+	}
+	switch {
+	case p.err == nil:
+		return StateActive
+	case IsSkipPlugin(p.err):
+		return StateSkipped
+	default:
+		return StateFailed
+	}
+}
+
+// StateErr returns the error which caused the current state, for plugins
+// which have not transitioned since initialization this is the init error.
+func (p *Plugin) StateErr() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.transitioned {
+		return p.stateErr
+	}
+	return p.err
+}
+
+func (p *Plugin) closer() (io.Closer, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c, ok := p.instance.(io.Closer)
+	return c, ok
+}
+
+func (p *Plugin) transition(s State, err error) {
+	p.mu.Lock()
+	p.state = s
+	p.stateErr = err
+	p.transitioned = true
+	p.mu.Unlock()
+}
+
+// MarkFailed transitions a plugin in the set to the failed state after
+// initialization, such as when an external process backing the plugin
+// exits. The set's cascade policy is applied to the transitive dependents
+// of the plugin and an event is emitted for every transition.
+func (ps *Set) MarkFailed(p *Plugin, err error) {
+	ps.lifecycle.Lock()
+	p.transition(StateFailed, err)
+	events := []Event{{Plugin: p.Registration.URI(), State: StateFailed, Err: err}}
+
+	var closers []io.Closer
+	if ps.cascade != CascadeNone {
+		cause := p.Registration.URI()
+		cerr := fmt.Errorf("dependency %s failed: %w", cause, err)
+		for _, d := range ps.dependents(p) {
+			switch d.State() {
+			case StateActive, StateDegraded:
+			default:
+				continue
+			}
+			switch ps.cascade {
+			case CascadeDegrade:
+				d.transition(StateDegraded, cerr)
+			case CascadeStop:
+				d.transition(StateStopped, cerr)
+				if c, ok := d.closer(); ok {
+					closers = append(closers, c)
+				}
+			}
+			events = append(events, Event{Plugin: d.Registration.URI(), State: d.State(), Err: cerr, Cause: cause})
+		}
+	}
+	ps.lifecycle.Unlock()
+
+	for _, c := range closers {
+		c.Close()
+	}
+	ps.publish()
+	ps.emit(events)
+}
+
+// dependents returns the plugins in the set which transitively require the
+// given plugin, in initialization order. Barriers are not dependencies.
+func (ps *Set) dependents(p *Plugin) []*Plugin {
+	affected := map[*Plugin]bool{p: true}
+	var dependents []*Plugin
+	for _, d := range ps.ordered {
+		if affected[d] {
+			continue
+		}
+		for dep := range ps.requires[d] {
+			if affected[dep] {
+				affected[d] = true
+				dependents = append(dependents, d)
+				break
+			}
+		}
+	}
+	return dependents
+}
+
+// requires returns true if the registration requires the dependency,
+// either directly, as an optional dependency or by a needed capability
+func requires(r, dep *Registration) bool {
+	if r.URI() == dep.URI() {
+		return false
+	}
+	for _, need := range r.Needs {
+		if dep.Satisfies(need) {
+			return true
+		}
+	}
+	for _, types := range [][]Type{r.Requires, r.Optional} {
+		for _, t := range types {
+			if t.Matches(dep) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Reevaluate initializes skipped plugins which allow re-evaluation again,
+// promoting them to active when their init function succeeds. An event is
+// emitted for each promoted plugin followed by an event for each of its
+// dependents with the promoted plugin as the cause. Returns the number of
+// promoted plugins.
+//
+// Plugins are initialized with Registration.Init using a copy of their
+// original InitContext, without holding the set's lifecycle lock, so init
+// functions may look up other plugins.
+func (ps *Set) Reevaluate() int {
+	ps.lifecycle.Lock()
+	var skipped []*Plugin
+	for _, p := range ps.ordered {
+		if p.Registration.ReevaluateSkip && p.ic != nil && p.State() == StateSkipped {
+			skipped = append(skipped, p)
+		}
+	}
+	ps.lifecycle.Unlock()
+
+	results := make([]*Plugin, len(skipped))
+	for i, p := range skipped {
+		ic := *p.ic
+		ic.Meta = &Meta{
+			Exports: map[string]string{},
+		}
+		results[i] = p.Registration.Init(&ic)
+	}
+
+	ps.lifecycle.Lock()
+	var (
+		events   []Event
+		promoted int
+	)
+	for i, p := range skipped {
+		res := results[i]
+		if p.State() != StateSkipped {
+			// Transitioned while being re-evaluated
+			continue
+		}
+		if err := res.Err(); err != nil {
+			if !IsSkipPlugin(err) {
+				p.transition(StateFailed, err)
+				events = append(events, Event{Plugin: p.Registration.URI(), State: StateFailed, Err: err})
+			}
+			continue
+		}
+		p.mu.Lock()
+		p.instance = res.instance
+		p.err = nil
+		p.Config = res.Config
+		p.Meta = res.Meta
+		p.ic = res.ic
+		p.state = StateActive
+		p.stateErr = nil
+		p.transitioned = true
+		p.mu.Unlock()
+		promoted++
+
+		cause := p.Registration.URI()
+		events = append(events, Event{Plugin: cause, State: StateActive})
+		for _, d := range ps.dependents(p) {
+			events = append(events, Event{Plugin: d.Registration.URI(), State: d.State(), Cause: cause})
+		}
+	}
+	ps.lifecycle.Unlock()
+
+	if len(events) > 0 {
+		ps.publish()
+	}
+	ps.emit(events)
+	return promoted
+}
+
+// ReevaluateEvery calls Reevaluate at the given interval until the context
+// is canceled.
+func (ps *Set) ReevaluateEvery(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			ps.Reevaluate()
+		}
+	}
+}
+
+func (ps *Set) emit(events []Event) {
+	for _, e := range events {
+		for _, h := range ps.handlers {
+			h(e)
+		}
+	}
+}
@@ -0,0 +1,786 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testCloser struct {
+	closed bool
+}
+
+func (c *testCloser) Close() error {
+	c.closed = true
+	return nil
+}
+
+func testDependentSet(t *testing.T, opts ...SetOpt) (*Set, map[string]*Plugin) {
+	t.Helper()
+	ps := NewPluginSet(opts...)
+	plugins := map[string]*Plugin{}
+	for _, p := range []*Plugin{
+		testPlugin("content", "local", &testCloser{}, nil),
+		testPlugin("metadata", "bolt", &testCloser{}, nil),
+		testPlugin("service", "images", &testCloser{}, nil),
+		testPlugin("warmup", "images", &testCloser{}, nil),
+		testPlugin("grpc", "introspection", &testCloser{}, nil),
+		testPlugin("snapshot", "native", &testCloser{}, nil),
+	} {
+		switch p.Registration.URI() {
+		case "metadata.bolt":
+			p.Registration.Requires = []Type{"content"}
+		case "service.images":
+			p.Registration.Requires = []Type{"metadata"}
+		case "warmup.images":
+			p.Registration.Barrier = []Type{"metadata"}
+		case "grpc.introspection":
+			p.Registration.Requires = []Type{"*"}
+		}
+		plugins[p.Registration.URI()] = p
+		if err := ps.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ps, plugins
+}
+
+func TestMarkFailedCascade(t *testing.T) {
+	failure := errors.New("process exited")
+	for _, tc := range []struct {
+		name     string
+		policy   CascadePolicy
+		expected map[string]State
+	}{
+		{
+			name:   "None",
+			policy: CascadeNone,
+			expected: map[string]State{
+				"content.local":      StateFailed,
+				"metadata.bolt":      StateActive,
+				"service.images":     StateActive,
+				"warmup.images":      StateActive,
+				"grpc.introspection": StateActive,
+				"snapshot.native":    StateActive,
+			},
+		},
+		{
+			name:   "Degrade",
+			policy: CascadeDegrade,
+			expected: map[string]State{
+				"content.local":      StateFailed,
+				"metadata.bolt":      StateDegraded,
+				"service.images":     StateDegraded,
+				"warmup.images":      StateActive,
+				"grpc.introspection": StateDegraded,
+				"snapshot.native":    StateActive,
+			},
+		},
+		{
+			name:   "Stop",
+			policy: CascadeStop,
+			expected: map[string]State{
+				"content.local":      StateFailed,
+				"metadata.bolt":      StateStopped,
+				"service.images":     StateStopped,
+				"warmup.images":      StateActive,
+				"grpc.introspection": StateStopped,
+				"snapshot.native":    StateActive,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var events []Event
+			ps, plugins := testDependentSet(t, WithCascadePolicy(tc.policy), WithEventHandler(func(e Event) {
+				events = append(events, e)
+			}))
+			ps.MarkFailed(plugins["content.local"], failure)
+
+			transitions := 0
+			for uri, state := range tc.expected {
+				p := plugins[uri]
+				if s := p.State(); s != state {
+					t.Errorf("unexpected state for %s: %s, expected %s", uri, s, state)
+				}
+				if state != StateActive {
+					transitions++
+					if !errors.Is(p.StateErr(), failure) {
+						t.Errorf("unexpected state error for %s: %v", uri, p.StateErr())
+					}
+				}
+				closed := p.instance.(*testCloser).closed
+				if closed != (state == StateStopped) {
+					t.Errorf("unexpected close for %s: %t", uri, closed)
+				}
+			}
+			if len(events) != transitions {
+				t.Fatalf("expected %d events, got %d", transitions, len(events))
+			}
+			if events[0].Plugin != "content.local" || events[0].Cause != "" {
+				t.Fatalf("unexpected first event %+v", events[0])
+			}
+			for _, e := range events[1:] {
+				if e.Cause != "content.local" {
+					t.Errorf("unexpected cause for %s: %q", e.Plugin, e.Cause)
+				}
+			}
+		})
+	}
+}
+
+func TestReevaluate(t *testing.T) {
+	present := false
+	var events []Event
+	ps := NewPluginSet(WithEventHandler(func(e Event) {
+		events = append(events, e)
+	}))
+	device := Registration{
+		Type:           "device",
+		ID:             "gpu",
+		ReevaluateSkip: true,
+		InitFn: func(ic *InitContext) (interface{}, error) {
+			if !present {
+				return nil, ErrSkipPlugin
+			}
+			ic.Meta.Exports["device"] = "/dev/gpu0"
+			return "gpu", nil
+		},
+	}
+	other := Registration{
+		Type: "device",
+		ID:   "other",
+		InitFn: func(*InitContext) (interface{}, error) {
+			return nil, ErrSkipPlugin
+		},
+	}
+	dependent := Registration{
+		Type:     "service",
+		ID:       "devices",
+		Requires: []Type{"device"},
+		InitFn: func(*InitContext) (interface{}, error) {
+			return "service", nil
+		},
+	}
+	for _, r := range []Registration{device, other, dependent} {
+		if err := ps.Add(r.Init(NewContext(context.Background(), ps, nil))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := ps.Reevaluate(); n != 0 {
+		t.Fatalf("expected no promotion, got %d", n)
+	}
+	present = true
+	if n := ps.Reevaluate(); n != 1 {
+		t.Fatalf("expected 1 promotion, got %d", n)
+	}
+
+	p := ps.Get("device", "gpu")
+	if p.State() != StateActive {
+		t.Fatalf("unexpected state %s", p.State())
+	}
+	if i, err := p.Instance(); err != nil || i != "gpu" {
+		t.Fatalf("unexpected instance %v: %v", i, err)
+	}
+	if p.Meta.Exports["device"] != "/dev/gpu0" {
+		t.Fatalf("expected exports to be updated, got %v", p.Meta.Exports)
+	}
+	if s := ps.Get("device", "other").State(); s != StateSkipped {
+		t.Fatalf("expected plugin without re-evaluation to remain skipped, got %s", s)
+	}
+	if len(events) != 2 || events[0].Plugin != "device.gpu" || events[1].Plugin != "service.devices" || events[1].Cause != "device.gpu" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
+
+func TestReevaluateInit(t *testing.T) {
+	var (
+		ps    = NewPluginSet()
+		trace = &InitTrace{}
+		ready = false
+	)
+	content := Registration{
+		Type: "content",
+		ID:   "local",
+		InitFn: func(*InitContext) (interface{}, error) {
+			return "content", nil
+		},
+	}
+	device := Registration{
+		Type:           "snapshotter",
+		ID:             "devmapper",
+		ReevaluateSkip: true,
+		Timeout:        time.Second,
+		InitFn: func(ic *InitContext) (interface{}, error) {
+			if !ready {
+				return nil, ErrSkipPlugin
+			}
+			// Looking up other plugins must not block on the set
+			return ic.GetByID("content", "local")
+		},
+	}
+	for _, r := range []Registration{content, device} {
+		if err := ps.Add(r.Init(NewContext(context.Background(), ps, nil, WithInitTrace(trace)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ready = true
+	done := make(chan int)
+	go func() {
+		done <- ps.Reevaluate()
+	}()
+	select {
+	case n := <-done:
+		if n != 1 {
+			t.Fatalf("expected 1 promotion, got %d", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("re-evaluation blocked")
+	}
+	if i, err := ps.Get("snapshotter", "devmapper").Instance(); err != nil || i != "content" {
+		t.Fatalf("unexpected instance %v: %v", i, err)
+	}
+	if len(trace.Entries) != 3 || trace.Entries[2].Plugin != "snapshotter.devmapper" || trace.Entries[2].Outcome != OutcomeInitialized {
+		t.Fatalf("expected re-evaluation to be traced, got %+v", trace.Entries)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	errCorrupt := errors.New("database corrupt")
+	newSet := func(critical bool) *Set {
+		ps := NewPluginSet()
+		for _, r := range []Registration{
+			{
+				Type:     "metadata",
+				ID:       "bolt",
+				Critical: critical,
+				InitFn: func(*InitContext) (interface{}, error) {
+					return "bolt", nil
+				},
+				Verify: func(_ context.Context, i interface{}) error {
+					if i != "bolt" {
+						t.Errorf("unexpected instance %v", i)
+					}
+					return errCorrupt
+				},
+			},
+			{
+				Type: "snapshotter",
+				ID:   "skipped",
+				InitFn: func(*InitContext) (interface{}, error) {
+					return nil, ErrSkipPlugin
+				},
+			},
+			{
+				Type: "service",
+				ID:   "ok",
+				InitFn: func(*InitContext) (interface{}, error) {
+					return "ok", nil
+				},
+				Verify: func(context.Context, interface{}) error {
+					return nil
+				},
+			},
+		} {
+			if err := ps.Add(r.Init(NewContext(context.Background(), ps, nil))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return ps
+	}
+
+	ps := newSet(false)
+	if err := ps.Verify(context.Background()); err != nil {
+		t.Fatalf("expected non-critical failure to be ignored, got %v", err)
+	}
+	if s := ps.Get("metadata", "bolt").State(); s != StateFailed {
+		t.Fatalf("expected failed verification to mark plugin failed, got %s", s)
+	}
+
+	err := newSet(true).Verify(context.Background())
+	var verr *VerifyError
+	if !errors.As(err, &verr) || !errors.Is(err, ErrCriticalPlugin) {
+		t.Fatalf("expected verify error, got %v", err)
+	}
+	if len(verr.Failures) != 1 || verr.Failures[0].Plugin != "metadata.bolt" || !errors.Is(verr.Failures[0].Err, errCorrupt) {
+		t.Fatalf("unexpected failures %+v", verr.Failures)
+	}
+}
+
+func TestSetGraph(t *testing.T) {
+	ps, _ := testDependentSet(t)
+	expected := []Dependency{
+		{Plugin: "metadata.bolt", Requires: "content.local"},
+		{Plugin: "service.images", Requires: "metadata.bolt"},
+		{Plugin: "grpc.introspection", Requires: "content.local"},
+		{Plugin: "grpc.introspection", Requires: "metadata.bolt"},
+		{Plugin: "grpc.introspection", Requires: "service.images"},
+		{Plugin: "grpc.introspection", Requires: "warmup.images"},
+		{Plugin: "grpc.introspection", Requires: "snapshot.native"},
+	}
+	cmpDependencies(t, ps.Graph(), expected)
+
+	if err := ps.Remove("metadata", "bolt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Remove("metadata", "bolt"); !errors.Is(err, ErrPluginNotFound) {
+		t.Fatalf("expected not found removing twice, got %v", err)
+	}
+	p := testPlugin("metadata", "sqlite", "sqlite", nil)
+	p.Registration.Requires = []Type{"content"}
+	if err := ps.Add(p); err != nil {
+		t.Fatal(err)
+	}
+	// the added plugin satisfies dependents already in the set
+	cmpDependencies(t, ps.Graph(), []Dependency{
+		{Plugin: "service.images", Requires: "metadata.sqlite"},
+		{Plugin: "grpc.introspection", Requires: "content.local"},
+		{Plugin: "grpc.introspection", Requires: "service.images"},
+		{Plugin: "grpc.introspection", Requires: "warmup.images"},
+		{Plugin: "grpc.introspection", Requires: "snapshot.native"},
+		{Plugin: "grpc.introspection", Requires: "metadata.sqlite"},
+		{Plugin: "metadata.sqlite", Requires: "content.local"},
+	})
+}
+
+func cmpDependencies(t *testing.T, actual, expected []Dependency) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatalf("unexpected dependencies %v, expected %v", actual, expected)
+	}
+	for i := range actual {
+		if actual[i] != expected[i] {
+			t.Fatalf("unexpected dependency %d: %v, expected %v", i, actual[i], expected[i])
+		}
+	}
+}
+
+func TestReportError(t *testing.T) {
+	ps := NewPluginSet()
+	errFirst := errors.New("first")
+	errLast := errors.New("last")
+	var retained *InitContext
+	r := Registration{
+		Type: "snapshotter",
+		ID:   "remote",
+		InitFn: func(ic *InitContext) (interface{}, error) {
+			retained = ic
+			ic.ReportError(errFirst)
+			return "remote", nil
+		},
+	}
+	ic := NewContext(context.Background(), ps, nil)
+	ic.ReportError(errors.New("ignored before init"))
+	if err := ps.Add(r.Init(ic)); err != nil {
+		t.Fatal(err)
+	}
+	retained.ReportError(errLast)
+	retained.ReportError(nil)
+
+	records := ps.Errors()
+	if len(records) != 1 {
+		t.Fatalf("unexpected records %+v", records)
+	}
+	if rec := records[0]; rec.Plugin != "snapshotter.remote" || rec.Count != 2 || rec.LastError != errLast || rec.LastTime.IsZero() {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	ps.ResetErrors("snapshotter.remote")
+	if records := ps.Errors(); len(records) != 0 {
+		t.Fatalf("expected errors to be reset, got %+v", records)
+	}
+}
+
+func TestFinalizers(t *testing.T) {
+	ps := NewPluginSet()
+	var order []string
+	errUnmount := errors.New("device busy")
+	finalizing := func(name string, err error) func(context.Context) error {
+		return func(context.Context) error {
+			order = append(order, name)
+			return err
+		}
+	}
+	for _, r := range []Registration{
+		{
+			Type: "snapshotter",
+			ID:   "overlayfs",
+			InitFn: func(ic *InitContext) (interface{}, error) {
+				ic.AddFinalizer("mount", finalizing("snapshotter.mount", errUnmount))
+				ic.AddFinalizer("cache", finalizing("snapshotter.cache", nil))
+				return nil, nil
+			},
+		},
+		{
+			Type:     "service",
+			ID:       "snapshots",
+			Requires: []Type{"snapshotter"},
+			InitFn: func(ic *InitContext) (interface{}, error) {
+				ic.AddFinalizer("temp", finalizing("service.temp", nil))
+				return nil, nil
+			},
+		},
+	} {
+		if err := ps.Add(r.Init(NewContext(context.Background(), ps, nil))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ps.GC(context.Background(), "service.snapshots"); err != nil {
+		t.Fatal(err)
+	}
+	err := ps.Finalize(context.Background())
+	if !errors.Is(err, errUnmount) {
+		t.Fatalf("expected finalizer failure to be reported, got %v", err)
+	}
+	expected := []string{"service.temp", "snapshotter.cache", "snapshotter.mount"}
+	if len(order) != len(expected) {
+		t.Fatalf("unexpected finalizer order %v", order)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("unexpected finalizer order %v", order)
+		}
+	}
+	if err := ps.Finalize(context.Background()); err != nil {
+		t.Fatalf("expected finalizers to run once, got %v", err)
+	}
+}
+
+type testSelfTester struct {
+	err   error
+	block bool
+}
+
+func (s *testSelfTester) SelfTest(ctx context.Context) error {
+	if s.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return s.err
+}
+
+func TestSelfTest(t *testing.T) {
+	errBroken := errors.New("broken")
+	ps := NewPluginSet()
+	for _, p := range []*Plugin{
+		testPlugin("content", "local", &testSelfTester{}, nil),
+		testPlugin("metadata", "bolt", &testCloser{}, nil),
+		testPlugin("snapshotter", "overlayfs", &testSelfTester{err: errBroken}, nil),
+		testPlugin("snapshotter", "devmapper", &testSelfTester{block: true}, nil),
+		testPlugin("snapshotter", "btrfs", nil, ErrSkipPlugin),
+	} {
+		if err := ps.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results := ps.SelfTest(context.Background(), WithSelfTestParallelism(2), WithSelfTestTimeout(10*time.Millisecond))
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %+v", results)
+	}
+	if results[0].Plugin != "content.local" || results[0].Err != nil {
+		t.Errorf("unexpected result %+v", results[0])
+	}
+	if results[1].Plugin != "snapshotter.overlayfs" || !errors.Is(results[1].Err, errBroken) {
+		t.Errorf("unexpected result %+v", results[1])
+	}
+	if results[2].Plugin != "snapshotter.devmapper" || !errors.Is(results[2].Err, context.DeadlineExceeded) {
+		t.Errorf("unexpected result %+v", results[2])
+	}
+}
+
+func TestSummary(t *testing.T) {
+	ps := NewPluginSet()
+	for _, p := range []*Plugin{
+		testPlugin("snapshotter", "overlayfs", "overlayfs", nil),
+		testPlugin("snapshotter", "native", "native", nil),
+		testPlugin("snapshotter", "btrfs", nil, ErrSkipPlugin),
+		testPlugin("content", "local", "local", nil),
+		testPlugin("runtime", "task", nil, errors.New("no shim")),
+	} {
+		if err := ps.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := ps.Summary()
+	if s.Total() != 5 {
+		t.Fatalf("expected 5 plugins, got %d", s.Total())
+	}
+	if expected := "content: 1 active; runtime: 1 failed; snapshotter: 2 active, 1 skipped"; s.String() != expected {
+		t.Fatalf("unexpected summary %q, expected %q", s.String(), expected)
+	}
+}
+
+type orderCloser struct {
+	uri    string
+	mu     *sync.Mutex
+	closed *[]string
+}
+
+func (c *orderCloser) Close() error {
+	c.mu.Lock()
+	*c.closed = append(*c.closed, c.uri)
+	c.mu.Unlock()
+	return nil
+}
+
+func TestShutdown(t *testing.T) {
+	for _, concurrency := range []int{1, 3} {
+		var (
+			mu     sync.Mutex
+			closed []string
+			events []string
+		)
+		ps := NewPluginSet(WithEventHandler(func(e Event) {
+			events = append(events, e.Plugin)
+		}))
+		for _, p := range []*Plugin{
+			testPlugin("content", "local", nil, nil),
+			testPlugin("metadata", "bolt", nil, nil),
+			testPlugin("service", "images", nil, nil),
+			testPlugin("snapshot", "native", nil, nil),
+			testPlugin("differ", "walking", nil, ErrSkipPlugin),
+			testPlugin("grpc", "introspection", nil, nil),
+		} {
+			uri := p.Registration.URI()
+			if p.err == nil {
+				p.instance = &orderCloser{uri: uri, mu: &mu, closed: &closed}
+			}
+			switch uri {
+			case "metadata.bolt":
+				p.Registration.Requires = []Type{"content"}
+			case "service.images":
+				p.Registration.Requires = []Type{"metadata"}
+			case "snapshot.native":
+				p.Registration.ShutdownPriority = 10
+			case "grpc.introspection":
+				p.Registration.Requires = []Type{"*"}
+			}
+			if err := ps.Add(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		finalized := false
+		ic := &InitContext{plugins: ps, registration: &Registration{Type: "differ", ID: "walking"}}
+		ic.AddFinalizer("tmp", func(context.Context) error {
+			finalized = true
+			return nil
+		})
+
+		if err := ps.Shutdown(context.Background(), WithShutdownConcurrency(concurrency)); err != nil {
+			t.Fatal(err)
+		}
+		if !finalized {
+			t.Error("expected finalizer of skipped plugin to run")
+		}
+		if len(events) != 5 {
+			t.Errorf("expected 5 stop events, got %v", events)
+		}
+		for _, p := range ps.GetAll() {
+			if p.err == nil && p.State() != StateStopped {
+				t.Errorf("expected %s to be stopped, got %s", p.Registration.URI(), p.State())
+			}
+		}
+
+		position := map[string]int{}
+		for i, uri := range closed {
+			position[uri] = i
+		}
+		for _, edge := range [][2]string{
+			{"grpc.introspection", "service.images"},
+			{"grpc.introspection", "snapshot.native"},
+			{"service.images", "metadata.bolt"},
+			{"metadata.bolt", "content.local"},
+		} {
+			if position[edge[0]] > position[edge[1]] {
+				t.Errorf("concurrency %d: expected %s to close before %s, got %v", concurrency, edge[0], edge[1], closed)
+			}
+		}
+		if concurrency == 1 {
+			if expected := "[grpc.introspection snapshot.native service.images metadata.bolt content.local]"; fmt.Sprint(closed) != expected {
+				t.Errorf("unexpected close order %v, expected %s", closed, expected)
+			}
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ps, plugins := testDependentSet(t)
+	s := ps.Snapshot()
+	if s.Generation != 6 || len(s.Plugins) != 6 {
+		t.Fatalf("unexpected snapshot generation %d with %d plugins", s.Generation, len(s.Plugins))
+	}
+
+	ps.MarkFailed(plugins["content.local"], errors.New("disk removed"))
+	if s.Plugins[0].State != StateActive {
+		t.Fatal("expected published snapshot to be immutable")
+	}
+	failed := ps.Snapshot()
+	if failed.Generation != 7 || failed.Plugins[0].State != StateFailed {
+		t.Fatalf("unexpected snapshot after failure %+v", failed.Plugins[0])
+	}
+
+	err := ps.Batch(func() error {
+		if err := ps.Remove("content", "local"); err != nil {
+			return err
+		}
+		if ps.Snapshot() != failed {
+			t.Error("expected no snapshot to be published during a batch")
+		}
+		return ps.Add(testPlugin("content", "local", "reloaded", nil))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := ps.Snapshot()
+	if reloaded.Generation != 8 || len(reloaded.Plugins) != 6 {
+		t.Fatalf("unexpected snapshot after reload generation %d with %d plugins", reloaded.Generation, len(reloaded.Plugins))
+	}
+	if last := reloaded.Plugins[5]; last.Registration.URI() != "content.local" || last.Instance != "reloaded" || last.State != StateActive {
+		t.Fatalf("unexpected reloaded plugin %+v", last)
+	}
+}
+
+func TestSnapshotMeta(t *testing.T) {
+	ps := NewPluginSet()
+	r := Registration{
+		Type: "content",
+		ID:   "local",
+		InitFn: func(ic *InitContext) (interface{}, error) {
+			ic.Meta.Exports["root"] = "/var/lib/content"
+			ic.Meta.Capabilities = append(ic.Meta.Capabilities, "gc")
+			return nil, nil
+		},
+	}
+	ic := NewContext(context.Background(), ps, nil)
+	p := r.Init(ic)
+	if err := ps.Add(p); err != nil {
+		t.Fatal(err)
+	}
+	s := ps.Snapshot()
+
+	ic.Meta.Exports["root"] = "/tmp"
+	p.Meta.Exports["address"] = "/run/content.sock"
+	p.Meta.Capabilities[0] = "changed"
+	meta := s.Plugins[0].Meta
+	if len(meta.Exports) != 1 || meta.Exports["root"] != "/var/lib/content" {
+		t.Fatalf("expected snapshot exports to be immutable, got %v", meta.Exports)
+	}
+	if len(meta.Capabilities) != 1 || meta.Capabilities[0] != "gc" {
+		t.Fatalf("expected snapshot capabilities to be immutable, got %v", meta.Capabilities)
+	}
+}
+
+type testPinger struct {
+	err error
+}
+
+func (p *testPinger) Ping(context.Context) error {
+	return p.err
+}
+
+func TestWatchdog(t *testing.T) {
+	var events []Event
+	ps := NewPluginSet(WithCascadePolicy(CascadeDegrade), WithEventHandler(func(e Event) {
+		events = append(events, e)
+	}))
+	snapshotter := &testPinger{}
+	proxy := testPlugin("snapshotter", "remote", snapshotter, nil)
+	images := testPlugin("service", "images", &testPinger{}, nil)
+	images.Registration.Requires = []Type{"snapshotter"}
+	for _, p := range []*Plugin{proxy, images} {
+		if err := ps.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := ps.NewWatchdog(WithPingFailures(2, 3))
+	ctx := context.Background()
+	snapshotter.err = errors.New("hung")
+	w.Check(ctx)
+	if proxy.State() != StateActive || len(events) != 0 {
+		t.Fatalf("expected single failure to be tolerated, got %s", proxy.State())
+	}
+	w.Check(ctx)
+	if proxy.State() != StateDegraded || len(events) != 1 || !errors.Is(events[0].Err, snapshotter.err) {
+		t.Fatalf("expected plugin to be degraded, got %s with events %+v", proxy.State(), events)
+	}
+
+	snapshotter.err = nil
+	w.Check(ctx)
+	if proxy.State() != StateActive || len(events) != 2 {
+		t.Fatalf("expected plugin to recover, got %s", proxy.State())
+	}
+
+	snapshotter.err = errors.New("hung again")
+	for i := 0; i < 3; i++ {
+		w.Check(ctx)
+	}
+	if proxy.State() != StateFailed || images.State() != StateDegraded {
+		t.Fatalf("expected plugin to fail and cascade, got %s and %s", proxy.State(), images.State())
+	}
+	if last := events[len(events)-1]; last.Plugin != "service.images" || last.Cause != "snapshotter.remote" {
+		t.Fatalf("unexpected cascade event %+v", last)
+	}
+}
+
+type hungPinger struct {
+	block chan struct{}
+}
+
+func (p *hungPinger) Ping(context.Context) error {
+	<-p.block
+	return nil
+}
+
+func TestWatchdogHungPing(t *testing.T) {
+	pinger := &hungPinger{block: make(chan struct{})}
+	defer close(pinger.block)
+	ps := NewPluginSet()
+	proxy := testPlugin("snapshotter", "remote", pinger, nil)
+	if err := ps.Add(proxy); err != nil {
+		t.Fatal(err)
+	}
+
+	w := ps.NewWatchdog(WithPingTimeout(10*time.Millisecond), WithPingFailures(1, 2))
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.Check(context.Background())
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchdog blocked on hung ping")
+	}
+	if proxy.State() != StateFailed || !errors.Is(proxy.StateErr(), context.DeadlineExceeded) {
+		t.Fatalf("expected hung plugin to fail with deadline error, got %s: %v", proxy.State(), proxy.StateErr())
+	}
+}
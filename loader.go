@@ -0,0 +1,358 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ConfigFunc returns the configuration a plugin is initialized with and
+// whether a configuration section was provided for it, as opposed to only
+// the registered defaults. The set being loaded is passed so fragments
+// provided with InitContext.ProvideConfig can be merged using
+// Set.MergeConfig before decoding.
+type ConfigFunc func(ctx context.Context, r *Registration, ps *Set) (config interface{}, provided bool, err error)
+
+// PreInitHook is called by Loader.Load before initializing a plugin, such
+// as to enforce a policy on the plugins a host runs. Returning an error
+// fails the plugin without calling its init function.
+type PreInitHook func(ic *InitContext, r *Registration) error
+
+// PostInitHook is called by Loader.Load after initializing a plugin with the
+// resulting plugin, which may have failed, and the time initialization took.
+type PostInitHook func(ic *InitContext, r *Registration, p *Plugin, d time.Duration)
+
+// Loader initializes the plugins of a registry in dependency order. It
+// performs the load loop otherwise implemented by each daemon embedding
+// this package: resolving the graph, creating the InitContext of each
+// plugin, calling Init and adding the result to a plugin set.
+type Loader struct {
+	registry    Registry
+	filter      DisableFilter
+	config      ConfigFunc
+	properties  map[string]string
+	graphOpts   []GraphOpt
+	contextOpts []InitContextOpt
+	setOpts     []SetOpt
+	parallelism int
+	retry       *RetryPolicy
+	preInit     []PreInitHook
+	postInit    []PostInitHook
+}
+
+// LoaderOpt is an option for NewLoader
+type LoaderOpt func(*Loader)
+
+// WithLoadFilter sets the filter used to disable plugins, by default every
+// plugin is enabled
+func WithLoadFilter(filter DisableFilter) LoaderOpt {
+	return func(l *Loader) {
+		l.filter = filter
+	}
+}
+
+// WithLoadConfig sets the function providing plugin configuration. Without
+// it, plugins are initialized with their registered default configuration.
+func WithLoadConfig(fn ConfigFunc) LoaderOpt {
+	return func(l *Loader) {
+		l.config = fn
+	}
+}
+
+// WithLoadProperties sets the properties passed to every InitContext, such
+// as the root and state directories. Each plugin receives its own copy.
+func WithLoadProperties(properties map[string]string) LoaderOpt {
+	return func(l *Loader) {
+		l.properties = properties
+	}
+}
+
+// WithLoadGraphOpts sets the options used to resolve the registration graph
+func WithLoadGraphOpts(opts ...GraphOpt) LoaderOpt {
+	return func(l *Loader) {
+		l.graphOpts = append(l.graphOpts, opts...)
+	}
+}
+
+// WithLoadContextOpts sets options applied to every InitContext, such as
+// the logger or readiness sources.
+func WithLoadContextOpts(opts ...InitContextOpt) LoaderOpt {
+	return func(l *Loader) {
+		l.contextOpts = append(l.contextOpts, opts...)
+	}
+}
+
+// WithLoadSetOpts sets the options used to create the plugin set
+func WithLoadSetOpts(opts ...SetOpt) LoaderOpt {
+	return func(l *Loader) {
+		l.setOpts = append(l.setOpts, opts...)
+	}
+}
+
+// WithLoadParallelism initializes up to n plugins concurrently. Plugins are
+// only initialized concurrently with plugins they have no dependency
+// relationship with, see Registry.Stages. The ConfigFunc may be called
+// concurrently.
+func WithLoadParallelism(n int) LoaderOpt {
+	return func(l *Loader) {
+		l.parallelism = n
+	}
+}
+
+// WithLoadLogger sets the base logger of every plugin, see WithLogger
+func WithLoadLogger(logger Logger) LoaderOpt {
+	return func(l *Loader) {
+		l.contextOpts = append(l.contextOpts, WithLogger(logger))
+	}
+}
+
+// WithLoadTimeout sets the time each plugin init function may run when the
+// registration does not set a Timeout, see WithInitTimeout
+func WithLoadTimeout(d time.Duration) LoaderOpt {
+	return func(l *Loader) {
+		l.contextOpts = append(l.contextOpts, WithInitTimeout(d))
+	}
+}
+
+// WithLoadRetry sets the retry policy of plugins whose registration does
+// not set a Retry policy
+func WithLoadRetry(policy RetryPolicy) LoaderOpt {
+	return func(l *Loader) {
+		l.retry = &policy
+	}
+}
+
+// WithPreInitHook adds a hook called before each plugin init attempt. Hooks
+// are called in the order added and may be called concurrently when using
+// WithLoadParallelism.
+func WithPreInitHook(hook PreInitHook) LoaderOpt {
+	return func(l *Loader) {
+		l.preInit = append(l.preInit, hook)
+	}
+}
+
+// WithPostInitHook adds a hook called after each plugin init attempt, such
+// as to log or record metrics of plugin initialization. Hooks are called in
+// the order added and may be called concurrently when using
+// WithLoadParallelism.
+func WithPostInitHook(hook PostInitHook) LoaderOpt {
+	return func(l *Loader) {
+		l.postInit = append(l.postInit, hook)
+	}
+}
+
+// NewLoader returns a loader for the plugins of the registry
+func NewLoader(registry Registry, opts ...LoaderOpt) *Loader {
+	l := &Loader{
+		registry: registry,
+	}
+	for _, o := range opts {
+		o(l)
+	}
+	return l
+}
+
+// LoadResult is the outcome of Loader.Load
+type LoadResult struct {
+	// Plugins is the set of initialized plugins, including those which
+	// failed or were skipped
+	Plugins *Set
+	// Graph is the resolved registration graph plugins were loaded from
+	Graph *RegistrationGraph
+	// Loaded lists the plugins initialized successfully, in order
+	Loaded []*Plugin
+	// Skipped lists the plugins which returned ErrSkipPlugin
+	Skipped []*Plugin
+	// Failed lists the plugins which failed to initialize
+	Failed []*Plugin
+	// Duration is how long loading took
+	Duration time.Duration
+}
+
+// Load resolves the registration graph and initializes every enabled plugin
+// in order. Plugin failures are recorded in the result and loading carries
+// on, unless the plugin is marked Critical, in which case loading stops and
+// an error wrapping ErrCriticalPlugin is returned along with the partial
+// result. An error is also returned if the graph cannot be resolved.
+//
+// With WithLoadParallelism, plugins are initialized in the stages computed
+// by Registry.Stages: the plugins of a stage are initialized concurrently
+// and added to the set in graph order once the whole stage completes, so a
+// plugin only observes the plugins of earlier stages.
+func (l *Loader) Load(ctx context.Context) (*LoadResult, error) {
+	start := time.Now()
+	res := &LoadResult{
+		Plugins: NewPluginSet(l.setOpts...),
+	}
+	defer func() {
+		res.Duration = time.Since(start)
+	}()
+	filter := l.filter
+	if filter == nil {
+		filter = func(*Registration) bool { return false }
+	}
+	var stages [][]*Registration
+	if err := catch(func() {
+		res.Graph = l.registry.Resolve(filter, l.graphOpts...)
+		if l.parallelism > 1 {
+			stages = l.registry.stages(filter, newGraphOptions(l.graphOpts))
+		}
+	}); err != nil {
+		return res, err
+	}
+	if stages == nil {
+		for _, r := range res.Graph.Ordered {
+			stages = append(stages, []*Registration{r})
+		}
+	}
+
+	for _, stage := range stages {
+		var critical error
+		for _, p := range l.initStage(ctx, res, stage) {
+			if err := res.Plugins.Add(p); err != nil {
+				return res, err
+			}
+			err := p.Err()
+			switch {
+			case err == nil:
+				res.Loaded = append(res.Loaded, p)
+			case IsSkipPlugin(err):
+				res.Skipped = append(res.Skipped, p)
+			default:
+				res.Failed = append(res.Failed, p)
+				if p.Registration.Critical && critical == nil {
+					critical = fmt.Errorf("%w: %s: %w", ErrCriticalPlugin, p.Registration.URI(), err)
+				}
+			}
+		}
+		if critical != nil {
+			return res, critical
+		}
+	}
+	return res, nil
+}
+
+// initStage initializes the registrations of a stage using up to the
+// configured number of goroutines, returning the plugins in stage order
+func (l *Loader) initStage(ctx context.Context, res *LoadResult, stage []*Registration) []*Plugin {
+	plugins := make([]*Plugin, len(stage))
+	if len(stage) == 1 {
+		plugins[0] = l.init(ctx, res, stage[0])
+		return plugins
+	}
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, l.parallelism)
+	)
+	for i := range stage {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			plugins[i] = l.init(ctx, res, stage[i])
+		}(i)
+	}
+	wg.Wait()
+	return plugins
+}
+
+// init creates the InitContext of the registration and initializes it,
+// retrying according to the retry policy of the registration or loader
+func (l *Loader) init(ctx context.Context, res *LoadResult, r *Registration) *Plugin {
+	var (
+		config   = r.Config
+		provided bool
+		err      error
+	)
+	if l.config != nil {
+		config, provided, err = l.config(ctx, r, res.Plugins)
+	}
+	retry := r.Retry
+	if retry == nil {
+		retry = l.retry
+	}
+	for attempt := 1; ; attempt++ {
+		ic := l.newContext(ctx, res)
+		if err != nil {
+			ic.Config = r.Config
+			return failedPlugin(r, ic, fmt.Errorf("failed to load config for %s: %w", r.URI(), err))
+		}
+		ic.Config, ic.ConfigProvided = config, provided
+		p := l.initHooked(ic, r)
+		if !retry.retryable(attempt, p.Err()) {
+			return p
+		}
+		timer := time.NewTimer(retry.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return p
+		}
+	}
+}
+
+// initHooked initializes the plugin, calling the pre init hooks before and
+// the post init hooks after
+func (l *Loader) initHooked(ic *InitContext, r *Registration) *Plugin {
+	var (
+		p     *Plugin
+		start = time.Now()
+	)
+	for _, hook := range l.preInit {
+		if err := hook(ic, r); err != nil {
+			p = failedPlugin(r, ic, err)
+			break
+		}
+	}
+	if p == nil {
+		p = r.Init(ic)
+	}
+	d := time.Since(start)
+	for _, hook := range l.postInit {
+		hook(ic, r, p, d)
+	}
+	return p
+}
+
+// failedPlugin returns the plugin for a registration which failed before
+// its init function could be called
+func failedPlugin(r *Registration, ic *InitContext, err error) *Plugin {
+	return &Plugin{
+		Registration: *r,
+		Config:       ic.Config,
+		Meta:         *ic.Meta,
+		err:          err,
+		ic:           ic,
+	}
+}
+
+// newContext returns an InitContext for a plugin loaded into the result
+func (l *Loader) newContext(ctx context.Context, res *LoadResult) *InitContext {
+	properties := make(map[string]string, len(l.properties))
+	for k, v := range l.properties {
+		properties[k] = v
+	}
+	opts := append([]InitContextOpt{WithRegistrationGraph(res.Graph)}, l.contextOpts...)
+	return NewContext(ctx, res.Plugins, properties, opts...)
+}
@@ -0,0 +1,400 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoader(t *testing.T) {
+	var (
+		registry Registry
+		order    []string
+		errBoom  = errors.New("boom")
+	)
+	initFn := func(err error) func(*InitContext) (interface{}, error) {
+		return func(ic *InitContext) (interface{}, error) {
+			order = append(order, ic.registration.URI())
+			if ic.Properties["root"] != "/var/lib" {
+				t.Errorf("%s: unexpected properties %v", ic.registration.URI(), ic.Properties)
+			}
+			return ic.Config, err
+		}
+	}
+	registry = registry.Register(&Registration{
+		Type:     "snapshotter",
+		ID:       "overlayfs",
+		Requires: []Type{"content"},
+		InitFn:   initFn(nil),
+	}).Register(&Registration{
+		Type:   "content",
+		ID:     "local",
+		Config: "default",
+		InitFn: initFn(nil),
+	}).Register(&Registration{
+		Type:   "snapshotter",
+		ID:     "btrfs",
+		InitFn: initFn(errBoom),
+	}).Register(&Registration{
+		Type:   "snapshotter",
+		ID:     "zfs",
+		InitFn: initFn(ErrSkipPlugin),
+	}).Register(&Registration{
+		Type:   "snapshotter",
+		ID:     "native",
+		InitFn: initFn(nil),
+	})
+
+	l := NewLoader(registry,
+		WithLoadFilter(func(r *Registration) bool { return r.ID == "native" }),
+		WithLoadProperties(map[string]string{"root": "/var/lib"}),
+		WithLoadConfig(func(_ context.Context, r *Registration, _ *Set) (interface{}, bool, error) {
+			if r.ID == "local" {
+				return "configured", true, nil
+			}
+			return r.Config, false, nil
+		}))
+	res, err := l.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"content.local", "snapshotter.overlayfs", "snapshotter.btrfs", "snapshotter.zfs"}; !reflect.DeepEqual(order, expected) {
+		t.Fatalf("unexpected init order %v, expected %v", order, expected)
+	}
+	if len(res.Loaded) != 2 || len(res.Skipped) != 1 || len(res.Failed) != 1 {
+		t.Fatalf("unexpected result: %d loaded, %d skipped, %d failed", len(res.Loaded), len(res.Skipped), len(res.Failed))
+	}
+	if !errors.Is(res.Failed[0].Err(), errBoom) {
+		t.Fatalf("unexpected failure %v", res.Failed[0].Err())
+	}
+	if len(res.Graph.Disabled) != 1 || res.Graph.Disabled[0].ID != "native" {
+		t.Fatalf("unexpected disabled plugins %v", res.Graph.Disabled)
+	}
+	instance, err := res.Plugins.Get("content", "local").Instance()
+	if err != nil || instance != "configured" {
+		t.Fatalf("unexpected content instance %v: %v", instance, err)
+	}
+	if len(res.Plugins.GetAll()) != 4 {
+		t.Fatalf("expected 4 plugins in set, got %d", len(res.Plugins.GetAll()))
+	}
+
+	// A failing critical plugin stops loading
+	registry[2].Critical = true
+	order = nil
+	res, err = l.Load(context.Background())
+	if !errors.Is(err, ErrCriticalPlugin) || !errors.Is(err, errBoom) {
+		t.Fatalf("expected critical plugin error, got %v", err)
+	}
+	if len(res.Failed) != 1 || len(order) != 3 {
+		t.Fatalf("unexpected result after critical failure: %v", order)
+	}
+}
+
+func TestLoaderTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	hang := func(*InitContext) (interface{}, error) {
+		<-block
+		return nil, nil
+	}
+	var registry Registry
+	registry = registry.Register(&Registration{
+		Type:    "snapshotter",
+		ID:      "devmapper",
+		Timeout: 10 * time.Millisecond,
+		InitFn:  hang,
+	}).Register(&Registration{
+		Type:   "snapshotter",
+		ID:     "blockfile",
+		InitFn: hang,
+	}).Register(&Registration{
+		Type:    "content",
+		ID:      "local",
+		Timeout: time.Minute,
+		InitFn:  func(*InitContext) (interface{}, error) { return "content", nil },
+	})
+
+	res, err := NewLoader(registry, WithLoadTimeout(20*time.Millisecond)).Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Loaded) != 1 || res.Loaded[0].Registration.ID != "local" {
+		t.Fatalf("unexpected loaded plugins %v", res.Loaded)
+	}
+	if len(res.Failed) != 2 {
+		t.Fatalf("expected 2 failed plugins, got %d", len(res.Failed))
+	}
+	for _, p := range res.Failed {
+		if !errors.Is(p.Err(), context.DeadlineExceeded) {
+			t.Fatalf("%s: expected deadline error, got %v", p.Registration.URI(), p.Err())
+		}
+	}
+}
+
+func TestLoaderParallel(t *testing.T) {
+	var (
+		registry Registry
+		started  sync.WaitGroup
+	)
+	started.Add(2)
+	independent := func(*InitContext) (interface{}, error) {
+		// Only returns once both independent plugins are initializing
+		started.Done()
+		done := make(chan struct{})
+		go func() {
+			started.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil, nil
+		case <-time.After(5 * time.Second):
+			return nil, errors.New("plugins not initialized concurrently")
+		}
+	}
+	registry = registry.Register(&Registration{
+		Type:   "content",
+		ID:     "local",
+		InitFn: independent,
+	}).Register(&Registration{
+		Type:   "snapshotter",
+		ID:     "native",
+		InitFn: independent,
+	}).Register(&Registration{
+		Type:     "service",
+		ID:       "images",
+		Requires: []Type{"content", "snapshotter"},
+		InitFn: func(ic *InitContext) (interface{}, error) {
+			if _, err := ic.GetSingle("content"); err != nil {
+				return nil, err
+			}
+			return ic.GetSingle("snapshotter")
+		},
+	})
+
+	res, err := NewLoader(registry, WithLoadParallelism(4)).Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Loaded) != 3 {
+		for _, p := range res.Failed {
+			t.Errorf("%s: %v", p.Registration.URI(), p.Err())
+		}
+		t.Fatalf("expected 3 loaded plugins, got %d", len(res.Loaded))
+	}
+	var order []string
+	for _, p := range res.Plugins.GetAll() {
+		order = append(order, p.Registration.URI())
+	}
+	if expected := []string{"content.local", "snapshotter.native", "service.images"}; !reflect.DeepEqual(order, expected) {
+		t.Fatalf("unexpected set order %v, expected %v", order, expected)
+	}
+}
+
+func TestLoaderRetry(t *testing.T) {
+	var (
+		registry       Registry
+		errUnavailable = errors.New("backend unavailable")
+		errFatal       = errors.New("invalid backend")
+		attempts       = map[string]int{}
+	)
+	failFor := func(n int, err error) func(*InitContext) (interface{}, error) {
+		return func(ic *InitContext) (interface{}, error) {
+			uri := ic.registration.URI()
+			attempts[uri]++
+			if attempts[uri] <= n {
+				return nil, err
+			}
+			return uri, nil
+		}
+	}
+	registry = registry.Register(&Registration{
+		Type:   "snapshotter",
+		ID:     "proxy",
+		InitFn: failFor(2, errUnavailable),
+	}).Register(&Registration{
+		Type:   "content",
+		ID:     "proxy",
+		InitFn: failFor(5, errUnavailable),
+	}).Register(&Registration{
+		Type:   "sandbox",
+		ID:     "proxy",
+		InitFn: failFor(1, errFatal),
+	}).Register(&Registration{
+		Type:   "diff",
+		ID:     "proxy",
+		Retry:  &RetryPolicy{Attempts: 6},
+		InitFn: failFor(5, errUnavailable),
+	})
+
+	res, err := NewLoader(registry, WithLoadRetry(RetryPolicy{
+		Attempts:   3,
+		Backoff:    time.Millisecond,
+		MaxBackoff: 2 * time.Millisecond,
+		Retryable: func(err error) bool {
+			return errors.Is(err, errUnavailable)
+		},
+	})).Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{
+		"snapshotter.proxy": 3,
+		"content.proxy":     3,
+		"sandbox.proxy":     1,
+		"diff.proxy":        6,
+	}
+	if !reflect.DeepEqual(attempts, expected) {
+		t.Fatalf("unexpected attempts %v, expected %v", attempts, expected)
+	}
+	if len(res.Loaded) != 2 || len(res.Failed) != 2 {
+		t.Fatalf("unexpected result: %d loaded, %d failed", len(res.Loaded), len(res.Failed))
+	}
+	if !errors.Is(res.Failed[0].Err(), errUnavailable) || !errors.Is(res.Failed[1].Err(), errFatal) {
+		t.Fatalf("unexpected failures %v, %v", res.Failed[0].Err(), res.Failed[1].Err())
+	}
+}
+
+func TestRetryPermanent(t *testing.T) {
+	policy := &RetryPolicy{Attempts: 3}
+	errUnavailable := errors.New("backend unavailable")
+	for _, tc := range []struct {
+		err       error
+		retryable bool
+	}{
+		{errUnavailable, true},
+		{fmt.Errorf("dial: %w", errUnavailable), true},
+		{&MissingConfigError{Plugin: "content.local", Section: "content"}, false},
+		{fmt.Errorf("config: %w", ErrMissingConfig), false},
+		{fmt.Errorf("platform: %w", ErrSkipPlugin), false},
+		{fmt.Errorf("root: %w", ErrMissingProperty), false},
+		{fmt.Errorf("store: %w", ErrInstanceType), false},
+		{fmt.Errorf("store: %w", ErrAccessDenied), false},
+		{fmt.Errorf("init: %w", &DependencyError{Plugin: "metadata.bolt", Dependency: "content", Err: ErrPluginNotFound}), false},
+	} {
+		if retryable := policy.retryable(1, tc.err); retryable != tc.retryable {
+			t.Errorf("retryable(%v) = %v, expected %v", tc.err, retryable, tc.retryable)
+		}
+	}
+
+	// a custom predicate decides for every error other than ErrSkipPlugin
+	policy.Retryable = func(error) bool { return true }
+	if !policy.retryable(1, ErrMissingConfig) || policy.retryable(1, ErrSkipPlugin) {
+		t.Fatal("unexpected decision of the custom predicate")
+	}
+}
+
+func TestLoaderMustGet(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []LoaderOpt
+	}{
+		{"Sequential", nil},
+		{"Timeout", []LoaderOpt{WithLoadTimeout(time.Minute)}},
+		{"Parallel", []LoaderOpt{WithLoadParallelism(4)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var registry Registry
+			registry = registry.Register(&Registration{
+				Type: "content",
+				ID:   "local",
+				InitFn: func(ic *InitContext) (interface{}, error) {
+					return "local", nil
+				},
+			}).Register(&Registration{
+				Type:     "metadata",
+				ID:       "bolt",
+				Requires: []Type{"content"},
+				InitFn: func(ic *InitContext) (interface{}, error) {
+					ic.MustGetSingle("content")
+					return ic.MustGetByID("snapshotter", "overlayfs"), nil
+				},
+			})
+
+			res, err := NewLoader(registry, tc.opts...).Load(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Loaded) != 1 || len(res.Failed) != 1 {
+				t.Fatalf("unexpected result: %d loaded, %d failed", len(res.Loaded), len(res.Failed))
+			}
+			var depErr *DependencyError
+			if !errors.As(res.Failed[0].Err(), &depErr) || depErr.Plugin != "metadata.bolt" || depErr.Dependency != "snapshotter.overlayfs" {
+				t.Fatalf("unexpected failure %v", res.Failed[0].Err())
+			}
+		})
+	}
+}
+
+func TestLoaderHooks(t *testing.T) {
+	var (
+		registry  Registry
+		errPolicy = errors.New("plugin not allowed")
+		events    []string
+	)
+	initFn := func(ic *InitContext) (interface{}, error) {
+		events = append(events, "init "+ic.registration.URI())
+		return nil, nil
+	}
+	registry = registry.Register(&Registration{
+		Type:   "content",
+		ID:     "local",
+		InitFn: initFn,
+	}).Register(&Registration{
+		Type:   "snapshotter",
+		ID:     "aufs",
+		InitFn: initFn,
+	})
+
+	res, err := NewLoader(registry,
+		WithPreInitHook(func(ic *InitContext, r *Registration) error {
+			events = append(events, "pre "+r.URI())
+			if r.ID == "aufs" {
+				return errPolicy
+			}
+			return nil
+		}),
+		WithPostInitHook(func(ic *InitContext, r *Registration, p *Plugin, d time.Duration) {
+			if d < 0 {
+				t.Errorf("%s: negative duration %s", r.URI(), d)
+			}
+			events = append(events, fmt.Sprintf("post %s %v", r.URI(), p.Err()))
+		}),
+	).Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"pre content.local",
+		"init content.local",
+		"post content.local <nil>",
+		"pre snapshotter.aufs",
+		"post snapshotter.aufs plugin not allowed",
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("unexpected events %v, expected %v", events, expected)
+	}
+	if len(res.Failed) != 1 || !errors.Is(res.Failed[0].Err(), errPolicy) {
+		t.Fatalf("expected policy failure, got %v", res.Failed)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+)
+
+// MigrationPolicy determines how a failing config migration is handled
+type MigrationPolicy int
+
+const (
+	// MigrationFail aborts the migration and returns the error
+	MigrationFail MigrationPolicy = iota
+	// MigrationWarn restores the configuration from before the failed
+	// migration and records the failure. Critical plugins always fail.
+	MigrationWarn
+)
+
+// MigrationFailure is a config migration which failed and was skipped
+type MigrationFailure struct {
+	// Plugin is the URI of the plugin whose migration failed
+	Plugin string
+	// Err is the migration error
+	Err error
+}
+
+// MigrateConfig runs the ConfigMigration of each registration in order on the
+// plugin configurations, keyed by plugin name. When a migration fails for a
+// non-critical plugin with the MigrationWarn policy, the configurations are
+// restored to their state before the migration and the failure is returned
+// for reporting rather than blocking startup.
+func (registry Registry) MigrateConfig(ctx context.Context, version int, plugins map[string]interface{}) ([]MigrationFailure, error) {
+	var failures []MigrationFailure
+	for _, r := range registry {
+		if r.ConfigMigration == nil {
+			continue
+		}
+		soft := r.MigrationPolicy == MigrationWarn && !r.Critical
+		var raw map[string]interface{}
+		if soft {
+			raw = copyConfig(plugins)
+		}
+		if err := r.ConfigMigration(ctx, version, plugins); err != nil {
+			if !soft {
+				return failures, fmt.Errorf("config migration failed for %s: %w", r.URI(), err)
+			}
+			for k := range plugins {
+				delete(plugins, k)
+			}
+			for k, v := range raw {
+				plugins[k] = v
+			}
+			failures = append(failures, MigrationFailure{Plugin: r.URI(), Err: err})
+		}
+	}
+	return failures, nil
+}
+
+// copyConfig deep copies the maps and slices of a decoded configuration
+func copyConfig(m map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		c[k] = copyConfigValue(v)
+	}
+	return c
+}
+
+func copyConfigValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return copyConfig(v)
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i := range v {
+			c[i] = copyConfigValue(v[i])
+		}
+		return c
+	default:
+		return v
+	}
+}
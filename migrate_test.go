@@ -0,0 +1,115 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMigrateConfig(t *testing.T) {
+	errMigrate := errors.New("migration failed")
+	// breaks the configuration before failing
+	failing := func(_ context.Context, _ int, plugins map[string]interface{}) error {
+		plugins["cri"].(map[string]interface{})["root"] = "broken"
+		delete(plugins, "old")
+		return errMigrate
+	}
+	renaming := func(_ context.Context, _ int, plugins map[string]interface{}) error {
+		plugins["new"] = plugins["old"]
+		delete(plugins, "old")
+		return nil
+	}
+	newConfig := func() map[string]interface{} {
+		return map[string]interface{}{
+			"cri": map[string]interface{}{"root": "/var/lib/cri"},
+			"old": map[string]interface{}{"enabled": true},
+		}
+	}
+
+	var register Registry
+	register = register.Register(&Registration{
+		Type:            "grpc",
+		ID:              "cri",
+		ConfigMigration: failing,
+		MigrationPolicy: MigrationWarn,
+	}).Register(&Registration{
+		Type:            "service",
+		ID:              "new",
+		ConfigMigration: renaming,
+	})
+
+	plugins := newConfig()
+	failures, err := register.MigrateConfig(context.Background(), 3, plugins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 1 || failures[0].Plugin != "grpc.cri" || !errors.Is(failures[0].Err, errMigrate) {
+		t.Fatalf("unexpected failures %+v", failures)
+	}
+	if root := plugins["cri"].(map[string]interface{})["root"]; root != "/var/lib/cri" {
+		t.Fatalf("expected raw config to be restored, got %v", root)
+	}
+	if _, ok := plugins["new"]; !ok {
+		t.Fatalf("expected later migrations to run, got %v", plugins)
+	}
+
+	register[0].Critical = true
+	if _, err := register.MigrateConfig(context.Background(), 3, newConfig()); !errors.Is(err, errMigrate) {
+		t.Fatalf("expected critical plugin migration to fail, got %v", err)
+	}
+}
+
+func TestProvideConfig(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "runtime", ID: "task"}).
+		Register(&Registration{
+			Type:      "internal",
+			ID:        "autodetect",
+			ConfigFor: []Type{"runtime"},
+			InitFn:    func(*InitContext) (interface{}, error) { return nil, nil },
+		})
+	cmpOrderedRefs(t, registry.GraphRefs(mockPluginFilter), []string{"internal.autodetect", "runtime.task"})
+
+	ps := NewPluginSet()
+	ic := NewContext(context.Background(), ps, nil)
+	registry[1].Init(ic)
+	if err := ic.ProvideConfig("runtime.task", map[string]interface{}{
+		"cgroup_driver": "systemd",
+		"options":       map[string]interface{}{"binary": "runc", "root": "/run"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ic.ProvideConfig("content.local", nil); !errors.Is(err, ErrInvalidConfigFor) {
+		t.Fatalf("expected undeclared target to fail, got %v", err)
+	}
+
+	raw := map[string]interface{}{"options": map[string]interface{}{"binary": "crun"}}
+	merged := ps.MergeConfig("runtime.task", raw)
+	expected := map[string]interface{}{
+		"cgroup_driver": "systemd",
+		"options":       map[string]interface{}{"binary": "crun", "root": "/run"},
+	}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Fatalf("unexpected merged config %v", merged)
+	}
+	if len(raw["options"].(map[string]interface{})) != 1 {
+		t.Fatalf("expected raw config to be unchanged, got %v", raw)
+	}
+}
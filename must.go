@@ -0,0 +1,116 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DependencyError is panicked by MustGetSingle and MustGetByID when a
+// dependency cannot be returned
+type DependencyError struct {
+	// Plugin is the URI of the plugin requesting the dependency, empty when
+	// not requested during plugin init
+	Plugin string
+	// Dependency is the requested type or plugin URI
+	Dependency string
+	// Candidates lists the plugins considered for the dependency along with
+	// their state, such as "snapshotter.btrfs (skipped)"
+	Candidates []string
+	// Err is the error returned looking up the dependency
+	Err error
+}
+
+func (e *DependencyError) Error() string {
+	var b strings.Builder
+	if e.Plugin != "" {
+		fmt.Fprintf(&b, "%s: ", e.Plugin)
+	}
+	fmt.Fprintf(&b, "dependency %s unavailable", e.Dependency)
+	if len(e.Candidates) == 0 {
+		b.WriteString(", no candidates")
+	} else {
+		fmt.Fprintf(&b, ", candidates [%s]", strings.Join(e.Candidates, ", "))
+	}
+	fmt.Fprintf(&b, ": %v", e.Err)
+	return b.String()
+}
+
+// Unwrap returns the lookup error
+func (e *DependencyError) Unwrap() error {
+	return e.Err
+}
+
+// MustGetSingle returns the instance GetSingle returns for the type,
+// panicking with a DependencyError if it returns an error. It is intended
+// for tightly coupled plugins which cannot be initialized without the
+// dependency; the panic is recovered by Registration.Init and recorded as
+// the init error of the plugin.
+func (i *InitContext) MustGetSingle(t Type) interface{} {
+	instance, err := i.GetSingle(t)
+	if err != nil {
+		panic(i.dependencyError(string(t), t, err))
+	}
+	return instance
+}
+
+// MustGetByID returns the instance GetByID returns for the type and ID,
+// panicking with a DependencyError if it returns an error.
+func (i *InitContext) MustGetByID(t Type, id string) interface{} {
+	instance, err := i.GetByID(t, id)
+	if err != nil {
+		panic(i.dependencyError(fmt.Sprintf("%s.%s", t, id), t, err))
+	}
+	return instance
+}
+
+// dependencyError returns the error for a dependency which could not be
+// returned, listing the accessible plugins of the type as candidates
+func (i *InitContext) dependencyError(dependency string, t Type, err error) *DependencyError {
+	e := &DependencyError{
+		Dependency: dependency,
+		Err:        err,
+	}
+	if i.registration != nil {
+		e.Plugin = i.registration.URI()
+	}
+	for _, p := range i.plugins.orderedByType(t) {
+		if i.allowed(p) == nil {
+			e.Candidates = append(e.Candidates, fmt.Sprintf("%s (%s)", p.Registration.URI(), p.State()))
+		}
+	}
+	return e
+}
+
+// recoverDependency returns the init function recovering a DependencyError
+// panicked by MustGetSingle or MustGetByID as the init error. Any other
+// panic is propagated.
+func recoverDependency(initFn func(*InitContext) (interface{}, error)) func(*InitContext) (interface{}, error) {
+	return func(ic *InitContext) (instance interface{}, err error) {
+		defer func() {
+			if v := recover(); v != nil {
+				depErr, ok := v.(*DependencyError)
+				if !ok {
+					panic(v)
+				}
+				instance, err = nil, depErr
+			}
+		}()
+		return initFn(ic)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import "context"
+
+// RegistrationOpt is an option used when creating a Registration with
+// NewRegistration
+type RegistrationOpt func(*Registration)
+
+// NewRegistration returns a registration for the plugin with the given type,
+// ID and init function. Unlike a Registration literal, fields added in later
+// releases are set with additional options.
+func NewRegistration(t Type, id string, initFn func(*InitContext) (interface{}, error), opts ...RegistrationOpt) *Registration {
+	r := &Registration{
+		Type:   t,
+		ID:     id,
+		InitFn: initFn,
+	}
+	for _, o := range opts {
+		o(r)
+	}
+	return r
+}
+
+// WithRequires adds plugin types or URIs the plugin requires
+func WithRequires(types ...Type) RegistrationOpt {
+	return func(r *Registration) {
+		r.Requires = append(r.Requires, types...)
+	}
+}
+
+// WithOptional adds plugin types or URIs initialized before the plugin when
+// they are present
+func WithOptional(types ...Type) RegistrationOpt {
+	return func(r *Registration) {
+		r.Optional = append(r.Optional, types...)
+	}
+}
+
+// WithConfig sets the default configuration of the plugin
+func WithConfig(config interface{}) RegistrationOpt {
+	return func(r *Registration) {
+		r.Config = config
+	}
+}
+
+// WithConfigMigration sets the function migrating configuration from older
+// versions
+func WithConfigMigration(fn func(context.Context, int, map[string]interface{}) error) RegistrationOpt {
+	return func(r *Registration) {
+		r.ConfigMigration = fn
+	}
+}
+
+// WithPlatforms restricts the plugin to the given operating systems and
+// architectures, an empty list supports any value
+func WithPlatforms(oses, arches []string) RegistrationOpt {
+	return func(r *Registration) {
+		r.OSes = oses
+		r.Arches = arches
+	}
+}
@@ -20,6 +20,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
 )
 
 var (
@@ -43,8 +48,73 @@ var (
 	// ErrInvalidRequires will be thrown if the requirements for a plugin are
 	// defined in an invalid manner.
 	ErrInvalidRequires = errors.New("invalid requires")
+
+	// ErrMissingConfig is returned when a plugin which requires explicit
+	// configuration is initialized without a configuration section.
+	ErrMissingConfig = errors.New("plugin: missing config")
+
+	// ErrCapabilityNotFound is used when no plugin of a required type declares
+	// the requested capabilities
+	ErrCapabilityNotFound = errors.New("plugin: capability not found")
+
+	// ErrCriticalPlugin is returned when a critical plugin fails
+	ErrCriticalPlugin = errors.New("plugin: critical plugin failed")
+
+	// ErrPluginCircularDependency is used when the ordering constraints of
+	// plugins contradict each other.
+	ErrPluginCircularDependency = errors.New("plugin: circular dependency")
+
+	// ErrPluginConflict is used when plugins declared as conflicting are
+	// enabled together.
+	ErrPluginConflict = errors.New("plugin: conflict")
+
+	// ErrInvalidOrderHint is used when an order hint contradicts the
+	// dependencies of the plugins it orders.
+	ErrInvalidOrderHint = errors.New("plugin: invalid order hint")
+
+	// ErrInvalidOptional will be thrown if the optional dependencies for a
+	// plugin are defined in an invalid manner.
+	ErrInvalidOptional = errors.New("invalid optional")
+
+	// ErrInvalidConfigFor will be thrown if a plugin provides configuration
+	// to a plugin it does not declare in ConfigFor.
+	ErrInvalidConfigFor = errors.New("invalid config for")
+
+	// ErrInvalidBefore will be thrown if the before constraints for a plugin
+	// are defined in an invalid manner.
+	ErrInvalidBefore = errors.New("invalid before")
+
+	// ErrInvalidBarrier will be thrown if the barrier for a plugin is
+	// defined in an invalid manner.
+	ErrInvalidBarrier = errors.New("invalid barrier")
+
+	// ErrUnsatisfiedRequirement is used when a required plugin type has no
+	// enabled plugins, see WithStrictRequires.
+	ErrUnsatisfiedRequirement = errors.New("plugin: unsatisfied requirement")
+
+	// ErrRegistrySealed is used when modifying a registry after it was
+	// sealed.
+	ErrRegistrySealed = errors.New("plugin: registry sealed")
 )
 
+// MissingConfigError is returned when initializing a plugin which requires
+// explicit configuration but no configuration section was provided for it.
+type MissingConfigError struct {
+	// Plugin is the URI of the plugin being initialized
+	Plugin string
+	// Section is the name of the expected configuration section
+	Section string
+}
+
+func (e *MissingConfigError) Error() string {
+	return fmt.Sprintf("%s requires configuration section %s: %v", e.Plugin, e.Section, ErrMissingConfig)
+}
+
+// Unwrap returns ErrMissingConfig
+func (e *MissingConfigError) Unwrap() error {
+	return ErrMissingConfig
+}
+
 // IsSkipPlugin returns true if the error is skipping the plugin
 func IsSkipPlugin(err error) bool {
 	return errors.Is(err, ErrSkipPlugin)
@@ -55,16 +125,173 @@ type Type string
 
 func (t Type) String() string { return string(t) }
 
+// Instance returns a constraint matching only the plugin with the given type
+// and ID, for use in Requires, Barrier and Before.
+func Instance(t Type, id string) Type {
+	return Type(t.String() + "." + id)
+}
+
+// Matches returns true if the constraint applies to the registration. A
+// constraint is either a plugin type, a full plugin URI matching a single
+// plugin, "*" matching every plugin or a glob pattern such as
+// "io.containerd.snapshotter.*" matching the type or URI of each plugin in a
+// type family. Version constraints are not checked.
+func (t Type) Matches(r *Registration) bool {
+	t, _, _ = t.constraint()
+	if t == "*" || t == r.Type || string(t) == r.URI() || r.HasAlias(string(t)) {
+		return true
+	}
+	if !t.isPattern() {
+		return false
+	}
+	if ok, _ := path.Match(string(t), string(r.Type)); ok {
+		return true
+	}
+	ok, _ := path.Match(string(t), r.URI())
+	return ok
+}
+
+// isPattern returns true if the type is a glob pattern other than "*"
+func (t Type) isPattern() bool {
+	return t != "*" && strings.ContainsAny(string(t), "*?[")
+}
+
+// validPattern returns false if the type is a malformed glob pattern
+func (t Type) validPattern() bool {
+	_, err := path.Match(string(t), "")
+	return err == nil
+}
+
 // Registration contains information for registering a plugin
 type Registration struct {
 	// Type of the plugin
 	Type Type
 	// ID of the plugin
 	ID string
+	// EnabledFn reports whether the plugin is enabled when computing the
+	// graph, such as to disable the plugin when a kernel feature is missing
+	// without returning ErrSkipPlugin from InitFn. The context is set with
+	// WithContext. Plugins for which EnabledFn returns false are disabled as
+	// if by the DisableFilter.
+	EnabledFn func(context.Context) bool
+	// Default designates the plugin as the instance returned by GetSingle
+	// when multiple plugins of the type are initialized. At most one plugin
+	// of each type may be the default.
+	Default bool
+	// Bundle is the name of the bundle the plugin was registered with, see
+	// Registry.RegisterBundle
+	Bundle string
+	// Aliases are previous IDs or full URIs of the plugin, such as after a
+	// rename. Constraints and Set.Get resolve aliases to the registration.
+	Aliases []string
+	// Version is the semantic version of the plugin implementation checked
+	// against the version constraints of plugins requiring it
+	Version string
 	// Config specific to the plugin
 	Config interface{}
-	// Requires is a list of plugins that the registered plugin requires to be available
+	// Labels are attributes of the plugin, such as "experimental" or
+	// "tier=storage", used to select plugins with a LabelSelector
+	Labels map[string]string
+	// RequiresConfig indicates the plugin cannot run with a zero-value
+	// configuration and must be explicitly configured. Initialization fails
+	// with a MissingConfigError if no configuration section was provided.
+	RequiresConfig bool
+	// Requires is a list of plugins that the registered plugin requires to be available.
+	// Entries are plugin types or full plugin URIs, see Instance, to depend
+	// on a specific plugin of a type, or glob patterns such as
+	// "io.containerd.snapshotter.*" to depend on every version of a type
+	// family. Entries may be followed by a version constraint such as
+	// "io.containerd.snapshotter.v1 >= 1.2" which every matching plugin must
+	// satisfy. A plugin may require other plugins of its own type, such as a
+	// caching snapshotter wrapping another snapshotter, but never itself.
 	Requires []Type
+	// Cardinality bounds the number of enabled plugins matching Requires
+	// entries, such as exactly one metadata plugin. Keys must be entries
+	// of Requires.
+	Cardinality map[Type]Cardinality
+	// Optional is a list of plugins which must be initialized before the
+	// registered plugin when they are present. Unlike Requires, missing
+	// optional plugins are expected and not reported.
+	Optional []Type
+	// Provides is a list of abstract capabilities the plugin provides, such
+	// as "content-store", allowing plugins of different types to satisfy the
+	// same need.
+	Provides []string
+	// Needs is a list of capabilities the plugin requires. Every enabled
+	// plugin providing a needed capability is initialized first.
+	Needs []string
+	// Barrier is a list of plugin types which must be initialized before the
+	// registered plugin even though the plugin does not use them. Unlike
+	// Requires, a barrier only affects the initialization order and does not
+	// represent a dependency.
+	Barrier []Type
+	// Before is a list of plugin types which must be initialized after the
+	// registered plugin, such as a config sanitizer which must run before all
+	// GRPC plugins. Before constraints which contradict dependencies result
+	// in a circular dependency.
+	Before []Type
+	// InitAfter is a list of plugin types or URIs the registered plugin is
+	// initialized after when they are present. Unlike Barrier, the hint is
+	// advisory and ignored when it contradicts other ordering constraints.
+	InitAfter []Type
+	// InitBefore is a list of plugin types or URIs the registered plugin is
+	// initialized before when they are present, such as tracing starting
+	// before every other plugin. Unlike Before, the hint is advisory and
+	// ignored when it contradicts other ordering constraints.
+	InitBefore []Type
+	// ConfigFor is a list of plugin types or plugin URIs the registered
+	// plugin provides configuration to, see InitContext.ProvideConfig. The
+	// plugin is initialized before the plugins it configures.
+	ConfigFor []Type
+	// Conflicts is a list of plugin types or plugin URIs which cannot be
+	// enabled together with the registered plugin, such as two task
+	// monitors managing the same cgroups.
+	Conflicts []Type
+
+	// Timeout limits how long InitFn may run, such as a snapshotter waiting
+	// on a device which never appears. When exceeded, the plugin fails with
+	// an error wrapping context.DeadlineExceeded and InitFn is abandoned.
+	// Zero uses the default set with WithInitTimeout, if any.
+	Timeout time.Duration
+	// Retry declares init errors which are retried by Loader.Load, such as
+	// a backend which is not reachable yet. When nil, the loader default
+	// set with WithLoadRetry applies.
+	Retry *RetryPolicy
+
+	// WaitFor names readiness sources, such as "network-online", which must
+	// report ready before the plugin is initialized. Sources are provided by
+	// the embedder with WithReadinessSources.
+	WaitFor []string
+
+	// Priority orders registrations without a dependency relationship,
+	// registrations with a higher priority are initialized first. Plugins
+	// with the same priority are ordered by registration.
+	Priority int
+
+	// OSes and Arches restrict the platforms the plugin supports, as GOOS and
+	// GOARCH values. Initialization is skipped with ErrSkipPlugin on other
+	// platforms. Empty lists support every platform.
+	OSes   []string
+	Arches []string
+
+	// Critical marks a plugin which the daemon cannot run without. Startup
+	// should be aborted when a critical plugin fails to initialize or its
+	// Verify hook fails.
+	Critical bool
+	// Verify is called by Set.Verify after all plugins have been initialized
+	// with the plugin instance to check the environment the plugin depends
+	// on, such as the integrity of a metadata database.
+	Verify func(context.Context, interface{}) error
+
+	// ReevaluateSkip allows a plugin which skipped initialization to be
+	// initialized again by Set.Reevaluate, for plugins whose preconditions
+	// may be met later such as a device appearing after boot.
+	ReevaluateSkip bool
+
+	// ShutdownPriority orders plugins which can be stopped at the same time
+	// by Set.Shutdown, plugins with a higher priority are stopped first.
+	// Plugins are always stopped after the plugins depending on them.
+	ShutdownPriority int
 
 	// InitFn is called when initializing a plugin. The registration and
 	// context are passed in. The init function may modify the registration to
@@ -79,17 +306,81 @@ type Registration struct {
 	// for the plugin. No validation is done on the value before performing
 	// the migration.
 	ConfigMigration func(context.Context, int, map[string]interface{}) error
+	// MigrationPolicy determines how a failing ConfigMigration is handled
+	MigrationPolicy MigrationPolicy
+
+	// Security declares the privileged operations performed by the plugin
+	// for auditing the attack surface of a daemon.
+	Security SecurityContext
 }
 
 // Init the registered plugin
 func (r Registration) Init(ic *InitContext) *Plugin {
-	p, err := r.InitFn(ic)
+	ic.registration = &r
+	ic.Logger = scopeLogger(ic.Logger, &r)
+	var (
+		p     interface{}
+		err   error
+		start = time.Now()
+	)
+	if !r.SupportsPlatform(runtime.GOOS, runtime.GOARCH) {
+		err = fmt.Errorf("%s is not supported on %s/%s: %w", r.URI(), runtime.GOOS, runtime.GOARCH, ErrSkipPlugin)
+	} else if r.RequiresConfig && !ic.ConfigProvided {
+		err = &MissingConfigError{
+			Plugin:  r.URI(),
+			Section: fmt.Sprintf("[plugins.%q]", r.URI()),
+		}
+	} else if err = ic.waitFor(&r); err == nil {
+		p, err = ic.initWithTimeout(&r)
+	}
+	if ic.trace != nil {
+		ic.trace.record(&r, ic.Config, time.Since(start), err)
+	}
 	return &Plugin{
 		Registration: r,
 		Config:       ic.Config,
 		Meta:         *ic.Meta,
 		instance:     p,
 		err:          err,
+		ic:           ic,
+	}
+}
+
+// initWithTimeout calls the init function of the registration, returning an
+// error once the registration or default timeout expires. An init function
+// still running after the timeout is abandoned rather than waited on.
+func (i *InitContext) initWithTimeout(r *Registration) (interface{}, error) {
+	initFn := r.InitFn
+	if i.profileDir != "" {
+		initFn = func(ic *InitContext) (interface{}, error) {
+			return profileInit(ic, r)
+		}
+	}
+	// recovered on the goroutine running the init function
+	initFn = recoverDependency(initFn)
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = i.timeout
+	}
+	if timeout <= 0 {
+		return initFn(i)
+	}
+	type result struct {
+		instance interface{}
+		err      error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		instance, err := initFn(i)
+		resCh <- result{instance, err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-resCh:
+		return res.instance, res.err
+	case <-timer.C:
+		return nil, fmt.Errorf("%s did not initialize within %s: %w", r.URI(), timeout, context.DeadlineExceeded)
 	}
 }
 
@@ -98,6 +389,46 @@ func (r *Registration) URI() string {
 	return r.Type.String() + "." + r.ID
 }
 
+// HasAlias returns true if the URI refers to the registration by one of its
+// aliases
+func (r *Registration) HasAlias(uri string) bool {
+	for _, a := range r.Aliases {
+		if a == uri || r.Type.String()+"."+a == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsPlatform returns true if the registration supports the GOOS and
+// GOARCH
+func (r *Registration) SupportsPlatform(os, arch string) bool {
+	return contains(r.OSes, os) && contains(r.Arches, arch)
+}
+
+// contains returns true if the list is empty or contains the value
+func contains(list []string, v string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Satisfies returns true if the registration provides the capability
+func (r *Registration) Satisfies(capability string) bool {
+	for _, c := range r.Provides {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
 // DisableFilter filters out disabled plugins
 type DisableFilter func(r *Registration) bool
 
@@ -109,70 +440,519 @@ type Registry []*Registration
 
 // Graph computes the ordered list of registrations based on their dependencies,
 // filtering out any plugins which match the provided filter.
-func (registry Registry) Graph(filter DisableFilter) []Registration {
+func (registry Registry) Graph(filter DisableFilter, opts ...GraphOpt) []Registration {
+	o := newGraphOptions(opts)
+	disabled := registry.disabled(filter, o)
+	ordered := make([]Registration, 0, len(registry)-len(disabled))
+	registry.walk(disabled, o, func(r *Registration) {
+		ordered = append(ordered, *r)
+	})
+	return ordered
+}
+
+// GraphRefs computes the same ordered list as Graph but returns references to
+// the registrations held by the registry rather than copies. The returned
+// registrations are shared with the registry and must be treated as read-only.
+func (registry Registry) GraphRefs(filter DisableFilter, opts ...GraphOpt) []*Registration {
+	o := newGraphOptions(opts)
+	disabled := registry.disabled(filter, o)
+	ordered := make([]*Registration, 0, len(registry)-len(disabled))
+	registry.walk(disabled, o, func(r *Registration) {
+		ordered = append(ordered, r)
+	})
+	return ordered
+}
+
+func (registry Registry) disabled(filter DisableFilter, o *graphOptions) map[*Registration]bool {
 	disabled := map[*Registration]bool{}
 	for _, r := range registry {
-		if filter(r) {
+		if o.disabled(filter, r) {
 			disabled[r] = true
 		}
 	}
+	return disabled
+}
 
-	ordered := make([]Registration, 0, len(registry)-len(disabled))
-	added := map[*Registration]bool{}
-	for _, r := range registry {
-		if disabled[r] {
+// walk calls fn for each enabled registration in initialization order,
+// returning the registrations disabled to resolve conflicts and by cascade.
+func (registry Registry) walk(disabled map[*Registration]bool, opts *graphOptions, fn func(*Registration)) ([]Conflict, []Cascade) {
+	w, conflicts := registry.walker(disabled, opts, fn)
+	for _, r := range w.registry {
+		if w.disabled[r] {
 			continue
 		}
-		children(r, registry, added, disabled, &ordered)
-		if !added[r] {
-			ordered = append(ordered, *r)
-			added[r] = true
+		w.add(r)
+	}
+	return conflicts, w.cascades
+}
+
+// walker returns a walker for the registrations in priority order after
+// applying conflicts, cascades, requirements and order hints.
+func (registry Registry) walker(disabled map[*Registration]bool, opts *graphOptions, fn func(*Registration)) (*walker, []Conflict) {
+	if opts.sortByURI {
+		registry = registry.sorted()
+	}
+	registry = registry.prioritized()
+	w := &walker{
+		registry: registry,
+		disabled: disabled,
+		added:    map[*Registration]bool{},
+		visiting: map[*Registration]bool{},
+		fn:       fn,
+
+		wildcardExclude: opts.wildcardExclude,
+	}
+	conflicts := w.conflicts(opts.disableConflicts)
+	if opts.cascadeDisable {
+		w.cascade()
+	}
+	if opts.strictRequires {
+		w.requirements()
+	}
+	w.pin(opts.hints)
+	w.soft()
+	return w, conflicts
+}
+
+// sorted returns a copy of the registrations ordered by URI
+func (registry Registry) sorted() Registry {
+	sorted := make(Registry, len(registry))
+	copy(sorted, registry)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].URI() < sorted[j].URI()
+	})
+	return sorted
+}
+
+// prioritized returns the registrations ordered by descending priority,
+// preserving the registration order of plugins with the same priority.
+func (registry Registry) prioritized() Registry {
+	for _, r := range registry {
+		if r.Priority != 0 {
+			sorted := make(Registry, len(registry))
+			copy(sorted, registry)
+			sort.SliceStable(sorted, func(i, j int) bool {
+				return sorted[i].Priority > sorted[j].Priority
+			})
+			return sorted
 		}
 	}
-	return ordered
+	return registry
+}
+
+type walker struct {
+	registry []*Registration
+	disabled map[*Registration]bool
+	added    map[*Registration]bool
+	visiting map[*Registration]bool
+	stack    []*Registration                   // registrations being added
+	pinned   map[*Registration][]*Registration // registration to those pinned before it
+	fn       func(*Registration)
+
+	wildcardExclude map[Type]bool
+	cascades        []Cascade
+	idx             *index
+
+	// unchecked skips version and cardinality checks when only the edges
+	// are needed
+	unchecked bool
+}
+
+// add adds the dependencies of reg followed by reg itself, panicking with a
+// CycleError if reg is reached again while its dependencies are being added.
+func (w *walker) add(reg *Registration) {
+	if w.added[reg] {
+		// dependencies of an added registration have already been added
+		return
+	}
+	if w.visiting[reg] {
+		panic(w.cycleTo(reg))
+	}
+	w.visiting[reg] = true
+	w.stack = append(w.stack, reg)
+	w.dependencies(reg, true, w.add)
+	w.stack = w.stack[:len(w.stack)-1]
+	delete(w.visiting, reg)
+	w.fn(reg)
+	w.added[reg] = true
+}
+
+// dependencies calls fn for each enabled registration which must be
+// initialized before reg, including order hints when pinned is set.
+func (w *walker) dependencies(reg *Registration, pinned bool, fn func(*Registration)) {
+	w.edges(reg, pinned, func(dep *Registration, _ EdgeKind, _ Type) {
+		fn(dep)
+	})
 }
 
-func children(reg *Registration, registry []*Registration, added, disabled map[*Registration]bool, ordered *[]Registration) {
-	for _, t := range reg.Requires {
-		for _, r := range registry {
-			if !disabled[r] && r.URI() != reg.URI() && (t == "*" || r.Type == t) {
-				children(r, registry, added, disabled, ordered)
-				if !added[r] {
-					*ordered = append(*ordered, *r)
-					added[r] = true
+// hardDependencies calls fn for each enabled registration reg uses through a
+// Requires, Optional or Needs entry, leaving out barriers and ordering
+// constraints such as Before, ConfigFor and order hints.
+func (w *walker) hardDependencies(reg *Registration, fn func(*Registration)) {
+	w.edges(reg, false, func(dep *Registration, kind EdgeKind, _ Type) {
+		switch kind {
+		case EdgeRequires, EdgeOptional, EdgeNeeds:
+			fn(dep)
+		}
+	})
+}
+
+// edges calls fn for each enabled registration which must be initialized
+// before reg along with the kind and entry of the edge.
+func (w *walker) edges(reg *Registration, pinned bool, fn func(*Registration, EdgeKind, Type)) {
+	for _, deps := range []struct {
+		kind  EdgeKind
+		types []Type
+	}{
+		{EdgeRequires, reg.Requires},
+		{EdgeOptional, reg.Optional},
+		{EdgeBarrier, reg.Barrier},
+	} {
+		for _, t := range deps.types {
+			_, vc, _ := t.constraint()
+			candidates, indexed := w.index().matching(t)
+			if !indexed {
+				candidates = w.registry
+			}
+			var n int
+			for _, r := range candidates {
+				if !w.disabled[r] && r != reg && (indexed || w.matches(t, r)) {
+					if vc != nil && !w.unchecked && !vc.satisfiedBy(r.Version) {
+						panic(&VersionError{Plugin: reg.URI(), Constraint: t, Dependency: r.URI(), Version: r.Version})
+					}
+					n++
+					fn(r, deps.kind, t)
 				}
 			}
+			if c, ok := reg.Cardinality[t]; ok && !w.unchecked && !c.satisfiedBy(n) {
+				panic(&CardinalityError{Plugin: reg.URI(), Requires: t, Cardinality: c, Count: n})
+			}
+		}
+	}
+	for _, need := range reg.Needs {
+		for _, r := range w.index().providers[need] {
+			if !w.disabled[r] && r != reg {
+				fn(r, EdgeNeeds, Type(need))
+			}
+		}
+	}
+	// registrations which must be initialized before this type
+	for _, r := range w.index().beforeCandidates(reg) {
+		if w.disabled[r] || r == reg {
+			continue
+		}
+		if kind, t, ok := w.before(r, reg); ok {
+			fn(r, kind, t)
+		}
+	}
+	if pinned {
+		for _, r := range w.pinned[reg] {
+			fn(r, EdgeOrderHint, "")
+		}
+	}
+}
+
+// index returns the index of the registrations, building it on first use
+func (w *walker) index() *index {
+	if w.idx == nil {
+		w.idx = newIndex(w.registry)
+	}
+	return w.idx
+}
+
+// before returns the kind and entry of the first Before or ConfigFor entry
+// of r matching reg, returning false if r need not be initialized before reg
+func (w *walker) before(r, reg *Registration) (EdgeKind, Type, bool) {
+	for _, deps := range []struct {
+		kind  EdgeKind
+		types []Type
+	}{
+		{EdgeBefore, r.Before},
+		{EdgeConfigFor, r.ConfigFor},
+	} {
+		for _, t := range deps.types {
+			if w.matches(t, reg) {
+				return deps.kind, t, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// matches returns true if the type constraint applies to the registration
+func (w *walker) matches(t Type, r *Registration) bool {
+	if t == "*" {
+		return !w.wildcardExclude[r.Type]
+	}
+	return t.Matches(r)
+}
+
+// RegistrationGraph is the effective initialization order of a registry along
+// with the outcome of the disable filter. The registrations are shared with
+// the registry and must be treated as read-only.
+type RegistrationGraph struct {
+	// Ordered is the list of enabled registrations in initialization order
+	Ordered []*Registration
+	// Disabled is the list of registrations removed by the filter, to
+	// resolve conflicts or by cascade
+	Disabled []*Registration
+	// Conflicts records the registrations disabled to resolve conflicts
+	// when using WithConflictAutoDisable
+	Conflicts []Conflict
+	// Cascades records the registrations disabled because a plugin they
+	// require was disabled when using WithCascadeDisable
+	Cascades []Cascade
+}
+
+// Resolve computes the ordered list of registrations in the same way as
+// GraphRefs and records which registrations were disabled by the filter.
+func (registry Registry) Resolve(filter DisableFilter, opts ...GraphOpt) *RegistrationGraph {
+	g := &RegistrationGraph{}
+	o := newGraphOptions(opts)
+	g.Conflicts, g.Cascades = registry.walk(registry.disabled(filter, o), o, func(r *Registration) {
+		g.Ordered = append(g.Ordered, r)
+	})
+	enabled := make(map[*Registration]struct{}, len(g.Ordered))
+	for _, r := range g.Ordered {
+		enabled[r] = struct{}{}
+	}
+	for _, r := range registry {
+		if _, ok := enabled[r]; !ok {
+			g.Disabled = append(g.Disabled, r)
 		}
 	}
+	return g
+}
+
+// After returns the registrations ordered after the plugin with the given
+// URI, returning nil if the plugin is not in the ordered list.
+func (g *RegistrationGraph) After(uri string) []*Registration {
+	for i, r := range g.Ordered {
+		if r.URI() == uri {
+			return g.Ordered[i+1:]
+		}
+	}
+	return nil
 }
 
 // Register adds the registration to a Registry and returns the
 // updated Registry, panicking if registration could not succeed.
 func (registry Registry) Register(r *Registration) Registry {
+	validate(r)
+	if err := checkUnique(registry, r); err != nil {
+		panic(err)
+	}
+	return append(registry, r)
+}
+
+// RegisterBundle adds a group of registrations spanning a feature, such as
+// the services and GRPC plugins of CRI, and returns the updated Registry.
+// Either every registration is added or, if any could not be registered, the
+// registry is left unchanged and the error is panicked. The bundle name is
+// recorded in the Bundle field of each registration.
+func (registry Registry) RegisterBundle(name string, regs ...*Registration) Registry {
+	bundled := registry[:len(registry):len(registry)]
+	for _, r := range regs {
+		if r.Bundle != "" && r.Bundle != name {
+			panic(fmt.Errorf("%s: already in bundle %s: %w", r.URI(), r.Bundle, ErrIDRegistered))
+		}
+		bundled = bundled.Register(r)
+	}
+	for _, r := range regs {
+		r.Bundle = name
+	}
+	return bundled
+}
+
+// Get returns the registration with the given type and ID
+func (registry Registry) Get(t Type, id string) (*Registration, bool) {
+	for _, r := range registry {
+		if r.Type == t && r.ID == id {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// Contains returns true if a registration has the given type and ID
+func (registry Registry) Contains(t Type, id string) bool {
+	_, ok := registry.Get(t, id)
+	return ok
+}
+
+// Types returns the sorted list of registered plugin types
+func (registry Registry) Types() []Type {
+	seen := map[Type]bool{}
+	var types []Type
+	for _, r := range registry {
+		if !seen[r.Type] {
+			seen[r.Type] = true
+			types = append(types, r.Type)
+		}
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// IDs returns the sorted IDs of the registrations of the given type
+func (registry Registry) IDs(t Type) []string {
+	var ids []string
+	for _, r := range registry {
+		if r.Type == t {
+			ids = append(ids, r.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Bundle returns the registrations added with the named bundle
+func (registry Registry) Bundle(name string) []*Registration {
+	var regs []*Registration
+	for _, r := range registry {
+		if r.Bundle == name {
+			regs = append(regs, r)
+		}
+	}
+	return regs
+}
+
+// Replace substitutes the registration with the same URI, such as a built-in
+// plugin replaced by a distribution, and returns the updated Registry. The
+// replacement keeps the position of the original registration. Panics with
+// ErrPluginNotFound if no registration has the URI.
+func (registry Registry) Replace(r *Registration) Registry {
+	validate(r)
+	for i, registered := range registry {
+		if registered.URI() == r.URI() {
+			replaced := make(Registry, len(registry))
+			copy(replaced, registry)
+			replaced[i] = r
+			return replaced
+		}
+	}
+	panic(fmt.Errorf("%s: %w", r.URI(), ErrPluginNotFound))
+}
+
+// Deregister returns the Registry without the registration with the given
+// type and ID. Unlike disabling a plugin with a DisableFilter, the
+// registration is no longer visible to any constraint. The Registry is
+// returned unchanged if no registration matches.
+func (registry Registry) Deregister(t Type, id string) Registry {
+	for i, r := range registry {
+		if r.Type == t && r.ID == id {
+			removed := make(Registry, 0, len(registry)-1)
+			removed = append(removed, registry[:i]...)
+			return append(removed, registry[i+1:]...)
+		}
+	}
+	return registry
+}
+
+// ConflictPolicy determines how Merge handles registrations with the same URI
+type ConflictPolicy int
+
+const (
+	// ConflictError fails the merge with ErrIDRegistered
+	ConflictError ConflictPolicy = iota
+	// ConflictKeepFirst keeps the registration already in the registry
+	ConflictKeepFirst
+	// ConflictKeepLast replaces the registration already in the registry,
+	// keeping its position
+	ConflictKeepLast
+)
+
+// Merge returns a Registry with the registrations of other appended, such as
+// a core registry combined with registries built by separate modules.
+// Registrations with a URI already in the registry are handled according to
+// the policy, with ConflictError every duplicate URI is reported.
+func (registry Registry) Merge(other Registry, policy ConflictPolicy) (Registry, error) {
+	index := make(map[string]int, len(registry)+len(other))
+	merged := make(Registry, len(registry), len(registry)+len(other))
+	copy(merged, registry)
+	for i, r := range merged {
+		index[r.URI()] = i
+	}
+	var errs []error
+	for _, r := range other {
+		i, ok := index[r.URI()]
+		if !ok {
+			index[r.URI()] = len(merged)
+			merged = append(merged, r)
+			continue
+		}
+		switch policy {
+		case ConflictKeepFirst:
+		case ConflictKeepLast:
+			merged[i] = r
+		default:
+			errs = append(errs, fmt.Errorf("%s: %w", r.URI(), ErrIDRegistered))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return merged, nil
+}
+
+// validate panics if the registration is invalid
+func validate(r *Registration) {
 	if r.Type == "" {
 		panic(ErrNoType)
 	}
 	if r.ID == "" {
 		panic(ErrNoPluginID)
 	}
-	if err := checkUnique(registry, r); err != nil {
-		panic(err)
-	}
-
 	for _, requires := range r.Requires {
 		if requires == "*" && len(r.Requires) != 1 {
 			panic(ErrInvalidRequires)
 		}
+		t, _, err := requires.constraint()
+		if err != nil {
+			panic(fmt.Errorf("%s: %w", r.URI(), err))
+		}
+		if string(t) == r.URI() {
+			panic(fmt.Errorf("%s: requires itself: %w", r.URI(), ErrInvalidRequires))
+		}
+	}
+	for t, c := range r.Cardinality {
+		if !c.valid() || !containsType(r.Requires, t) {
+			panic(fmt.Errorf("%s: cardinality %s of %s: %w", r.URI(), c, t, ErrInvalidRequires))
+		}
+	}
+	for _, optional := range r.Optional {
+		if optional == "*" && len(r.Optional) != 1 {
+			panic(ErrInvalidOptional)
+		}
+		if _, _, err := optional.constraint(); err != nil {
+			panic(fmt.Errorf("%s: %w", r.URI(), ErrInvalidOptional))
+		}
+	}
+	for _, barrier := range r.Barrier {
+		if (barrier == "*" && len(r.Barrier) != 1) || !barrier.validPattern() {
+			panic(ErrInvalidBarrier)
+		}
+	}
+	for _, t := range r.Before {
+		if (t == "*" && len(r.Before) != 1) || !t.validPattern() {
+			panic(ErrInvalidBefore)
+		}
 	}
-
-	return append(registry, r)
 }
 
 func checkUnique(registry Registry, r *Registration) error {
 	for _, registered := range registry {
-		if r.URI() == registered.URI() {
+		if r.URI() == registered.URI() || registered.HasAlias(r.URI()) {
 			return fmt.Errorf("%s: %w", r.URI(), ErrIDRegistered)
 		}
+		if r.Default && registered.Default && r.Type == registered.Type {
+			return fmt.Errorf("%s: default %s already registered by %s: %w", r.URI(), r.Type, registered.URI(), ErrPluginMultipleInstances)
+		}
+		for _, a := range r.Aliases {
+			if registered.URI() == a || registered.URI() == r.Type.String()+"."+a {
+				return fmt.Errorf("%s: alias %s: %w", r.URI(), a, ErrIDRegistered)
+			}
+		}
 	}
 	return nil
 }
@@ -17,8 +17,15 @@
 package plugin
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -347,6 +354,46 @@ func TestPluginGraph(t *testing.T) {
 				"metadata.bolt",
 			},
 		},
+		// test barrier
+		{
+			input: []*Registration{
+				{
+					Type: "warmup",
+					ID:   "images",
+					Barrier: []Type{
+						"metadata",
+					},
+				},
+				{
+					Type: "metadata",
+					ID:   "bolt",
+				},
+			},
+			expectedURI: []string{
+				"metadata.bolt",
+				"warmup.images",
+			},
+		},
+		// test before
+		{
+			input: []*Registration{
+				{
+					Type: "grpc",
+					ID:   "containers",
+				},
+				{
+					Type: "internal",
+					ID:   "sanitizer",
+					Before: []Type{
+						"grpc",
+					},
+				},
+			},
+			expectedURI: []string{
+				"internal.sanitizer",
+				"grpc.containers",
+			},
+		},
 		// test disable
 		{
 			input: []*Registration{
@@ -366,6 +413,80 @@ func TestPluginGraph(t *testing.T) {
 				return r.Type == "disable"
 			},
 		},
+		// test optional
+		{
+			input: []*Registration{
+				{
+					Type: "gc",
+					ID:   "scheduler",
+					Optional: []Type{
+						"metadata",
+						"tracing",
+					},
+				},
+				{
+					Type: "metadata",
+					ID:   "bolt",
+				},
+			},
+			expectedURI: []string{
+				"metadata.bolt",
+				"gc.scheduler",
+			},
+		},
+		// test needs
+		{
+			input: []*Registration{
+				{
+					Type:  "service",
+					ID:    "content",
+					Needs: []string{"content-store"},
+				},
+				{
+					Type:     "content",
+					ID:       "local",
+					Provides: []string{"content-store"},
+				},
+				{
+					Type:     "proxy",
+					ID:       "remote",
+					Provides: []string{"content-store"},
+				},
+			},
+			expectedURI: []string{
+				"content.local",
+				"proxy.remote",
+				"service.content",
+			},
+		},
+		// test requires specific instance
+		{
+			input: []*Registration{
+				{
+					Type: "service",
+					ID:   "snapshots",
+					Requires: []Type{
+						Instance("snapshotter", "overlayfs"),
+					},
+				},
+				{
+					Type: "snapshotter",
+					ID:   "btrfs",
+					Requires: []Type{
+						"service",
+					},
+				},
+				{
+					Type: "snapshotter",
+					ID:   "overlayfs",
+				},
+			},
+			expectedURI: []string{
+				"snapshotter.overlayfs",
+				"service.snapshots",
+				"snapshotter.btrfs",
+			},
+		},
 	} {
 		var register Registry
 		for _, in := range testcase.input {
@@ -512,3 +633,1120 @@ func testPlugin(t Type, id string, i interface{}, err error) *Plugin {
 		err:      err,
 	}
 }
+
+func TestRegistrationGraph(t *testing.T) {
+	var register Registry
+	register = register.Register(&Registration{
+		Type:     "grpc",
+		ID:       "introspection",
+		Requires: []Type{"*"},
+	}).Register(&Registration{
+		Type: "service",
+		ID:   "container",
+	}).Register(&Registration{
+		Type: "disable",
+		ID:   "disable",
+	})
+
+	g := register.Resolve(func(r *Registration) bool {
+		return r.Type == "disable"
+	})
+	cmpOrderedRefs(t, g.Ordered, []string{"service.container", "grpc.introspection"})
+	cmpOrderedRefs(t, g.Disabled, []string{"disable.disable"})
+
+	ic := NewContext(context.Background(), NewPluginSet(), nil, WithRegistrationGraph(g))
+	if ic.RegistrationGraph() != g {
+		t.Fatal("expected registration graph to be set on init context")
+	}
+	if NewContext(context.Background(), NewPluginSet(), nil).RegistrationGraph() != nil {
+		t.Fatal("expected no registration graph by default")
+	}
+
+	var remaining []*Registration
+	reg := *g.Ordered[0]
+	reg.InitFn = func(ic *InitContext) (interface{}, error) {
+		remaining = ic.RemainingRegistrations()
+		return nil, nil
+	}
+	reg.Init(ic)
+	cmpOrderedRefs(t, remaining, []string{"grpc.introspection"})
+}
+
+func cmpOrderedRefs(t *testing.T, ordered []*Registration, expectedURI []string) {
+	t.Helper()
+	if len(ordered) != len(expectedURI) {
+		t.Fatalf("ordered compare failed, %d != %d", len(ordered), len(expectedURI))
+	}
+	for i := range ordered {
+		if ordered[i].URI() != expectedURI[i] {
+			t.Fatalf("graph failed, expected: %s, but return: %s", expectedURI[i], ordered[i].URI())
+		}
+	}
+}
+
+func benchmarkRegistry(n int) Registry {
+	var register Registry
+	for i := 0; i < n; i++ {
+		r := &Registration{
+			Type: Type(fmt.Sprintf("type%d", i%10)),
+			ID:   fmt.Sprintf("id%d", i),
+		}
+		if i%10 > 0 {
+			r.Requires = []Type{Type(fmt.Sprintf("type%d", i%10-1))}
+		}
+		register = register.Register(r)
+	}
+	return register
+}
+
+func BenchmarkGraph(b *testing.B) {
+	register := benchmarkRegistry(300)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		register.Graph(mockPluginFilter)
+	}
+}
+
+// benchmarkBeforeRegistry extends benchmarkRegistry with Before entries,
+// pattern Before entries and a wildcard dependent so the graph exercises
+// the before candidates of every registration.
+func benchmarkBeforeRegistry(n int) Registry {
+	register := benchmarkRegistry(n)
+	for i, r := range register {
+		switch {
+		case i%20 == 0:
+			r.Before = []Type{"type5", Instance("type9", fmt.Sprintf("id%d", i+9))}
+		case i%10 == 0:
+			r.Before = []Type{"type7.*"}
+		}
+	}
+	return register.Register(&Registration{Type: "introspection", ID: "all", Requires: []Type{"*"}})
+}
+
+func BenchmarkGraphBefore(b *testing.B) {
+	register := benchmarkBeforeRegistry(300)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		register.Graph(mockPluginFilter)
+	}
+}
+
+func BenchmarkGraphRefs(b *testing.B) {
+	register := benchmarkRegistry(300)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		register.GraphRefs(mockPluginFilter)
+	}
+}
+
+func TestRegisterInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		reg  *Registration
+		err  error
+	}{
+		{
+			name: "NoType",
+			reg:  &Registration{ID: "id"},
+			err:  ErrNoType,
+		},
+		{
+			name: "NoID",
+			reg:  &Registration{Type: "type"},
+			err:  ErrNoPluginID,
+		},
+		{
+			name: "WildcardRequires",
+			reg:  &Registration{Type: "type", ID: "id", Requires: []Type{"*", "other"}},
+			err:  ErrInvalidRequires,
+		},
+		{
+			name: "WildcardBefore",
+			reg:  &Registration{Type: "type", ID: "id", Before: []Type{"*", "other"}},
+			err:  ErrInvalidBefore,
+		},
+		{
+			name: "WildcardBarrier",
+			reg:  &Registration{Type: "type", ID: "id", Barrier: []Type{"other", "*"}},
+			err:  ErrInvalidBarrier,
+		},
+		{
+			name: "InvalidVersionConstraint",
+			reg:  &Registration{Type: "type", ID: "id", Requires: []Type{"other ~> 1.2"}},
+			err:  ErrInvalidRequires,
+		},
+		{
+			name: "WildcardOptional",
+			reg:  &Registration{Type: "type", ID: "id", Optional: []Type{"other", "*"}},
+			err:  ErrInvalidOptional,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				v := recover()
+				err, ok := v.(error)
+				if !ok || !errors.Is(err, tc.err) {
+					t.Fatalf("expected panic with %v, got %v", tc.err, v)
+				}
+			}()
+			var register Registry
+			register.Register(tc.reg)
+		})
+	}
+}
+
+func TestRequiresConfig(t *testing.T) {
+	var called bool
+	r := Registration{
+		Type:           "service",
+		ID:             "configured",
+		RequiresConfig: true,
+		InitFn: func(*InitContext) (interface{}, error) {
+			called = true
+			return "instance", nil
+		},
+	}
+
+	p := r.Init(NewContext(context.Background(), NewPluginSet(), nil))
+	var mce *MissingConfigError
+	if err := p.Err(); !errors.As(err, &mce) || !errors.Is(err, ErrMissingConfig) {
+		t.Fatalf("expected missing config error, got %v", err)
+	}
+	if mce.Section != `[plugins."service.configured"]` {
+		t.Fatalf("unexpected section %q", mce.Section)
+	}
+	if called {
+		t.Fatal("init function should not be called without config")
+	}
+
+	ic := NewContext(context.Background(), NewPluginSet(), nil)
+	ic.ConfigProvided = true
+	if p := r.Init(ic); p.Err() != nil || !called {
+		t.Fatalf("expected plugin to initialize with config, got %v", p.Err())
+	}
+}
+
+func TestPlatformConstraints(t *testing.T) {
+	r := Registration{
+		Type:   "snapshotter",
+		ID:     "windows",
+		OSes:   []string{"windows"},
+		Arches: []string{"amd64", "arm64"},
+		InitFn: func(*InitContext) (interface{}, error) {
+			return "instance", nil
+		},
+	}
+	if !r.SupportsPlatform("windows", "arm64") || r.SupportsPlatform("linux", "amd64") || r.SupportsPlatform("windows", "386") {
+		t.Fatal("unexpected platform support")
+	}
+	if runtime.GOOS != "windows" {
+		if p := r.Init(NewContext(context.Background(), NewPluginSet(), nil)); !IsSkipPlugin(p.Err()) {
+			t.Fatalf("expected plugin to be skipped, got %v", p.Err())
+		}
+	}
+	r.OSes = []string{runtime.GOOS}
+	r.Arches = nil
+	if p := r.Init(NewContext(context.Background(), NewPluginSet(), nil)); p.Err() != nil {
+		t.Fatalf("expected plugin to initialize, got %v", p.Err())
+	}
+}
+
+type testLogger struct {
+	nopLogger
+	messages []string
+}
+
+func (l *testLogger) Info(msg string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprint(append([]interface{}{msg}, args...)...))
+}
+
+func TestInitContextObservability(t *testing.T) {
+	ic := NewContext(context.Background(), NewPluginSet(), nil)
+	if ic.Logger == nil {
+		t.Fatal("expected default logger")
+	}
+	ic.Logger.Info("discarded")
+	if ic.TracerProvider != nil || ic.MeterProvider != nil {
+		t.Fatal("expected no tracer or meter provider by default")
+	}
+
+	l := &testLogger{}
+	ic = NewContext(context.Background(), NewPluginSet(), nil,
+		WithLogger(l), WithTracerProvider("tracer"), WithMeterProvider("meter"))
+	ic.Logger.Info("message")
+	if len(l.messages) != 1 {
+		t.Fatalf("expected logger to be used, got %v", l.messages)
+	}
+	if ic.TracerProvider != "tracer" || ic.MeterProvider != "meter" {
+		t.Fatal("expected tracer and meter provider to be set")
+	}
+}
+
+func TestScopedLogger(t *testing.T) {
+	l := &testLogger{}
+	r := Registration{
+		Type: "io.containerd.snapshotter.v1",
+		ID:   "overlayfs",
+		InitFn: func(ic *InitContext) (interface{}, error) {
+			ic.Logger.Info("initializing", "root", "/var/lib")
+			return nil, nil
+		},
+	}
+	ic := NewContext(context.Background(), NewPluginSet(), nil, WithLogger(l))
+	r.Init(ic)
+	r.Init(ic)
+	expected := fmt.Sprint("initializing", "type", "io.containerd.snapshotter.v1", "id", "overlayfs", "root", "/var/lib")
+	if len(l.messages) != 2 || l.messages[0] != expected || l.messages[1] != expected {
+		t.Fatalf("unexpected messages %q, expected %q", l.messages, expected)
+	}
+}
+
+func TestProperties(t *testing.T) {
+	ic := NewContext(context.Background(), NewPluginSet(), map[string]string{
+		PropertyRootDir:     "/var/lib/containerd/io.containerd.content.v1.content",
+		PropertyGRPCAddress: "/run/containerd/containerd.sock",
+	})
+	if root, err := ic.RootDir(); err != nil || root != "/var/lib/containerd/io.containerd.content.v1.content" {
+		t.Fatalf("unexpected root dir %q: %v", root, err)
+	}
+	if addr, err := ic.GRPCAddress(); err != nil || addr != "/run/containerd/containerd.sock" {
+		t.Fatalf("unexpected grpc address %q: %v", addr, err)
+	}
+	if _, err := ic.StateDir(); !errors.Is(err, ErrMissingProperty) {
+		t.Fatalf("expected missing property error, got %v", err)
+	}
+
+	r := Registration{
+		Type: "io.containerd.ttrpc.v1",
+		ID:   "task",
+		InitFn: func(ic *InitContext) (interface{}, error) {
+			return ic.TTRPCAddress()
+		},
+	}
+	p := r.Init(ic)
+	if err := p.Err(); !errors.Is(err, ErrMissingProperty) || !strings.Contains(err.Error(), "io.containerd.ttrpc.v1.task") {
+		t.Fatalf("expected missing property error naming the plugin, got %v", err)
+	}
+}
+
+func TestInitProfiling(t *testing.T) {
+	dir := t.TempDir()
+	r := Registration{
+		Type: "service",
+		ID:   "profiled",
+		InitFn: func(*InitContext) (interface{}, error) {
+			return "instance", nil
+		},
+	}
+	p := r.Init(NewContext(context.Background(), NewPluginSet(), nil, WithInitProfiling(dir)))
+	if i, err := p.Instance(); err != nil || i != "instance" {
+		t.Fatalf("unexpected instance %v: %v", i, err)
+	}
+	for _, name := range []string{"service.profiled.cpu.pprof", "service.profiled.heap.pprof"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected profile %s: %v", name, err)
+		}
+	}
+}
+
+func TestChildPluginSet(t *testing.T) {
+	parent := NewPluginSet()
+	for _, p := range []*Plugin{
+		testPlugin("content", "content", "parent-content", nil),
+		testPlugin("snapshotter", "native", "parent-native", nil),
+	} {
+		if err := parent.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	child := NewPluginSet(WithParent(parent, "content"))
+	if err := child.Add(testPlugin("content", "other", "child-content", nil)); !errors.Is(err, ErrPluginInitialized) {
+		t.Fatalf("expected delegated type to be rejected, got %v", err)
+	}
+	if err := child.Add(testPlugin("snapshotter", "native", "child-native", nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	ic := InitContext{plugins: child}
+	if i, err := ic.GetSingle("content"); err != nil || i != "parent-content" {
+		t.Fatalf("expected content from parent, got %v: %v", i, err)
+	}
+	if i, err := ic.GetByID("content", "content"); err != nil || i != "parent-content" {
+		t.Fatalf("expected content from parent, got %v: %v", i, err)
+	}
+	if i, err := ic.GetSingle("snapshotter"); err != nil || i != "child-native" {
+		t.Fatalf("expected snapshotter from child, got %v: %v", i, err)
+	}
+	if len(child.GetAll()) != 1 {
+		t.Fatalf("expected only child plugins, got %d", len(child.GetAll()))
+	}
+}
+
+func TestGraphCircularDependency(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		input []*Registration
+	}{
+		{
+			name: "Requires",
+			input: []*Registration{
+				{Type: "a", ID: "a", Requires: []Type{"b"}},
+				{Type: "b", ID: "b", Requires: []Type{"a"}},
+			},
+		},
+		{
+			name: "BeforeContradictsRequires",
+			input: []*Registration{
+				{Type: "internal", ID: "sanitizer", Before: []Type{"grpc"}, Requires: []Type{"service"}},
+				{Type: "service", ID: "containers", Requires: []Type{"grpc"}},
+				{Type: "grpc", ID: "containers"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var register Registry
+			for _, r := range tc.input {
+				register = register.Register(r)
+			}
+			defer func() {
+				err, ok := recover().(error)
+				if !ok || !errors.Is(err, ErrPluginCircularDependency) {
+					t.Fatalf("expected circular dependency panic, got %v", err)
+				}
+			}()
+			register.Graph(mockPluginFilter)
+		})
+	}
+}
+
+func TestRequireCapability(t *testing.T) {
+	plugins := NewPluginSet()
+	overlay := testPlugin("snapshotter", "overlayfs", "overlayfs", nil)
+	overlay.Meta.Capabilities = []string{"remap-ids"}
+	stargz := testPlugin("snapshotter", "stargz", "stargz", nil)
+	stargz.Meta.Capabilities = []string{"remote-snapshot", "remap-ids"}
+	skipped := testPlugin("differ", "walking", nil, ErrSkipPlugin)
+	for _, p := range []*Plugin{overlay, stargz, skipped} {
+		if err := plugins.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ic := InitContext{plugins: plugins}
+
+	if err := ic.RequireCapability("snapshotter", "remote-snapshot", "remap-ids"); err != nil {
+		t.Fatalf("expected capabilities to be satisfied, got %v", err)
+	}
+	if err := ic.RequireCapability("snapshotter", "lazy-pull"); !errors.Is(err, ErrCapabilityNotFound) {
+		t.Fatalf("expected capability not found, got %v", err)
+	}
+	if err := ic.RequireCapability("differ", "any"); !errors.Is(err, ErrPluginNotFound) {
+		t.Fatalf("expected plugin not found, got %v", err)
+	}
+}
+
+func TestSecuritySummary(t *testing.T) {
+	registry := Registry{}.Register(&Registration{
+		Type:     "grpc",
+		ID:       "server",
+		Security: SecurityContext{Listens: true},
+	}).Register(&Registration{
+		Type:     "runtime",
+		ID:       "shim",
+		Security: SecurityContext{ExecsBinaries: true, HostDevices: true},
+	}).Register(&Registration{
+		Type: "content",
+		ID:   "local",
+	})
+
+	s := registry.SecuritySummary()
+	if len(s.Listens) != 1 || s.Listens[0] != "grpc.server" {
+		t.Errorf("unexpected listeners %v", s.Listens)
+	}
+	if len(s.ExecsBinaries) != 1 || s.ExecsBinaries[0] != "runtime.shim" {
+		t.Errorf("unexpected exec %v", s.ExecsBinaries)
+	}
+	if len(s.HostDevices) != 1 || s.HostDevices[0] != "runtime.shim" {
+		t.Errorf("unexpected host devices %v", s.HostDevices)
+	}
+}
+
+func TestGetByTypeOrdered(t *testing.T) {
+	plugins := NewPluginSet(WithShims(Shim{
+		From:    "snapshotter.v0",
+		To:      "snapshotter",
+		Convert: func(i interface{}) (interface{}, error) { return i, nil },
+	}))
+	for _, p := range []*Plugin{
+		testPlugin("snapshotter", "overlayfs", "overlayfs", nil),
+		testPlugin("snapshotter", "btrfs", nil, ErrSkipPlugin),
+		testPlugin("snapshotter.v0", "native", "native", nil),
+		testPlugin("snapshotter", "zfs", "zfs", nil),
+	} {
+		if err := plugins.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ic := InitContext{plugins: plugins}
+
+	for i := 0; i < 10; i++ {
+		ordered, err := ic.GetByTypeOrdered("snapshotter")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, p := range ordered {
+			ids = append(ids, p.Registration.ID)
+		}
+		if fmt.Sprint(ids) != "[overlayfs native zfs]" {
+			t.Fatalf("unexpected order %v", ids)
+		}
+	}
+	if _, err := ic.GetByTypeOrdered("differ"); !errors.Is(err, ErrPluginNotFound) {
+		t.Fatalf("expected plugin not found, got %v", err)
+	}
+
+	instances, err := ic.GetAllByType("snapshotter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []PluginInstance{
+		{ID: "overlayfs", Instance: "overlayfs"},
+		{ID: "native", Instance: "native"},
+		{ID: "zfs", Instance: "zfs"},
+	}
+	if !reflect.DeepEqual(instances, expected) {
+		t.Fatalf("unexpected instances %v, expected %v", instances, expected)
+	}
+	if _, err := ic.GetAllByType("differ"); !errors.Is(err, ErrPluginNotFound) {
+		t.Fatalf("expected plugin not found, got %v", err)
+	}
+}
+
+func TestAccessControl(t *testing.T) {
+	plugins := NewPluginSet(WithAccessControl(RestrictAccess(map[Type][]string{
+		"sandbox": {"cri.runtime"},
+	})))
+	for _, p := range []*Plugin{
+		testPlugin("sandbox", "controller", "controller", nil),
+		testPlugin("content", "local", "local", nil),
+	} {
+		if err := plugins.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cri := &InitContext{Context: context.Background(), plugins: plugins, registration: &Registration{Type: "cri", ID: "runtime"}}
+	if i, err := cri.GetByID("sandbox", "controller"); err != nil || i != "controller" {
+		t.Fatalf("expected cri to access sandbox controller, got %v: %v", i, err)
+	}
+
+	other := &InitContext{Context: context.Background(), plugins: plugins, registration: &Registration{Type: "grpc", ID: "tasks"}}
+	if _, err := other.GetByID("sandbox", "controller"); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("expected access denied, got %v", err)
+	}
+	if _, err := other.GetSingle("sandbox"); !errors.Is(err, ErrPluginNotFound) {
+		t.Fatalf("expected denied plugins to be hidden, got %v", err)
+	}
+	if all := other.GetAll(); len(all) != 1 || all[0].Registration.ID != "local" {
+		t.Fatalf("unexpected plugins %v", all)
+	}
+	if i, err := other.GetSingle("content"); err != nil || i != "local" {
+		t.Fatalf("expected unrestricted access, got %v: %v", i, err)
+	}
+
+	other.Context = WithCaller(context.Background(), "cri.runtime")
+	if _, err := other.GetByID("sandbox", "controller"); err != nil {
+		t.Fatalf("expected context caller to take precedence, got %v", err)
+	}
+}
+
+func TestDisableLabels(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "snapshotter", ID: "overlayfs", Labels: map[string]string{"tier": "storage"}}).
+		Register(&Registration{Type: "snapshotter", ID: "erofs", Labels: map[string]string{"tier": "storage", "experimental": ""}}).
+		Register(&Registration{Type: "content", ID: "local", Labels: map[string]string{"tier": "storage", "required": "true"}}).
+		Register(&Registration{Type: "grpc", ID: "tasks", Labels: map[string]string{"tier": "api"}})
+
+	filter, err := DisableLabels("experimental", "tier=storage, !required")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmpOrderedRefs(t, registry.GraphRefs(filter), []string{"content.local", "grpc.tasks"})
+
+	filter, err = DisableLabels("tier!=storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmpOrderedRefs(t, registry.GraphRefs(filter), []string{"snapshotter.overlayfs", "snapshotter.erofs", "content.local"})
+
+	for _, invalid := range []string{"", "tier=storage,", "=storage", "!"} {
+		if _, err := DisableLabels(invalid); err == nil {
+			t.Errorf("expected selector %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "snapshotter", ID: "overlayfs"}).
+		Register(&Registration{Type: "metadata", ID: "bolt", Requires: []Type{"content"}})
+
+	replacement := &Registration{Type: "snapshotter", ID: "overlayfs", Requires: []Type{"metadata"}}
+	replaced := registry.Replace(replacement)
+	if registry[1] == replacement {
+		t.Fatal("expected original registry to be unchanged")
+	}
+	if replaced[1] != replacement {
+		t.Fatal("expected replacement to keep its position")
+	}
+	cmpOrderedRefs(t, replaced.GraphRefs(mockPluginFilter), []string{"content.local", "metadata.bolt", "snapshotter.overlayfs"})
+
+	defer func() {
+		if err, ok := recover().(error); !ok || !errors.Is(err, ErrPluginNotFound) {
+			t.Fatalf("expected not found panic, got %v", err)
+		}
+	}()
+	registry.Replace(&Registration{Type: "snapshotter", ID: "btrfs"})
+}
+
+func TestDeregister(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "snapshotter", ID: "overlayfs"}).
+		Register(&Registration{Type: "grpc", ID: "introspection", Requires: []Type{"*"}})
+
+	removed := registry.Deregister("snapshotter", "overlayfs")
+	cmpOrderedRefs(t, removed, []string{"content.local", "grpc.introspection"})
+	if r, ok := registry.Get("snapshotter", "overlayfs"); !ok || r.URI() != "snapshotter.overlayfs" {
+		t.Fatalf("expected registration, got %v", r)
+	}
+	if removed.Contains("snapshotter", "overlayfs") || !removed.Contains("content", "local") {
+		t.Fatal("unexpected registrations after deregister")
+	}
+	if types := registry.Register(&Registration{Type: "snapshotter", ID: "btrfs"}).Types(); fmt.Sprint(types) != "[content grpc snapshotter]" {
+		t.Fatalf("unexpected types %v", types)
+	}
+	if ids := registry.Register(&Registration{Type: "snapshotter", ID: "btrfs"}).IDs("snapshotter"); fmt.Sprint(ids) != "[btrfs overlayfs]" {
+		t.Fatalf("unexpected ids %v", ids)
+	}
+	cmpOrderedRefs(t, registry, []string{"content.local", "snapshotter.overlayfs", "grpc.introspection"})
+	if unchanged := removed.Deregister("snapshotter", "overlayfs"); len(unchanged) != 2 {
+		t.Fatalf("expected registry to be unchanged, got %d registrations", len(unchanged))
+	}
+}
+
+func TestReadinessSources(t *testing.T) {
+	var waited []string
+	sources := map[string]ReadinessSource{
+		"network-online": func(context.Context) error {
+			waited = append(waited, "network-online")
+			return nil
+		},
+		"device-plugin": func(context.Context) error {
+			waited = append(waited, "device-plugin")
+			return fmt.Errorf("socket not present: %w", ErrSkipPlugin)
+		},
+	}
+	r := Registration{
+		Type:    "cni",
+		ID:      "network",
+		WaitFor: []string{"network-online"},
+		InitFn: func(*InitContext) (interface{}, error) {
+			return "instance", nil
+		},
+	}
+	if p := r.Init(NewContext(context.Background(), NewPluginSet(), nil, WithReadinessSources(sources))); p.Err() != nil {
+		t.Fatalf("expected plugin to initialize, got %v", p.Err())
+	}
+
+	r.WaitFor = []string{"network-online", "device-plugin"}
+	if p := r.Init(NewContext(context.Background(), NewPluginSet(), nil, WithReadinessSources(sources))); !IsSkipPlugin(p.Err()) {
+		t.Fatalf("expected plugin to be skipped, got %v", p.Err())
+	}
+	if fmt.Sprint(waited) != "[network-online network-online device-plugin]" {
+		t.Fatalf("unexpected waits %v", waited)
+	}
+
+	r.WaitFor = []string{"disk-mounted"}
+	if p := r.Init(NewContext(context.Background(), NewPluginSet(), nil, WithReadinessSources(sources))); !errors.Is(p.Err(), ErrReadinessSourceNotFound) {
+		t.Fatalf("expected missing source error, got %v", p.Err())
+	}
+}
+
+func TestMerge(t *testing.T) {
+	var core, module Registry
+	core = core.Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "snapshotter", ID: "overlayfs"})
+	module = module.Register(&Registration{Type: "snapshotter", ID: "overlayfs", Requires: []Type{"content"}}).
+		Register(&Registration{Type: "snapshotter", ID: "stargz"})
+
+	if _, err := core.Merge(module, ConflictError); !errors.Is(err, ErrIDRegistered) {
+		t.Fatalf("expected duplicate error, got %v", err)
+	}
+
+	merged, err := core.Merge(module, ConflictKeepFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmpOrderedRefs(t, merged, []string{"content.local", "snapshotter.overlayfs", "snapshotter.stargz"})
+	if merged[1] != core[1] {
+		t.Fatal("expected first registration to be kept")
+	}
+
+	merged, err = core.Merge(module, ConflictKeepLast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmpOrderedRefs(t, merged, []string{"content.local", "snapshotter.overlayfs", "snapshotter.stargz"})
+	if merged[1] != module[0] || core[1] == module[0] {
+		t.Fatal("expected last registration to replace the first in the merged registry only")
+	}
+}
+
+func TestSyncRegistry(t *testing.T) {
+	var (
+		registry SyncRegistry
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := registry.Register(&Registration{Type: "snapshotter", ID: fmt.Sprint(i)}); err != nil {
+				t.Error(err)
+			}
+			registry.Snapshot().GraphRefs(mockPluginFilter)
+		}(i)
+	}
+	wg.Wait()
+
+	snapshot := registry.Snapshot()
+	if len(snapshot) != 10 {
+		t.Fatalf("expected 10 registrations, got %d", len(snapshot))
+	}
+	if err := registry.Register(&Registration{Type: "snapshotter", ID: "0"}); !errors.Is(err, ErrIDRegistered) {
+		t.Fatalf("expected duplicate error, got %v", err)
+	}
+	if err := registry.Register(&Registration{Type: "snapshotter"}); !errors.Is(err, ErrNoPluginID) {
+		t.Fatalf("expected missing id error, got %v", err)
+	}
+	if err := registry.Replace(&Registration{Type: "content", ID: "local"}); !errors.Is(err, ErrPluginNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if !registry.Deregister("snapshotter", "3") || registry.Deregister("snapshotter", "3") {
+		t.Fatal("expected deregister to remove the registration once")
+	}
+	if len(snapshot) != 10 || len(registry.Snapshot()) != 9 {
+		t.Fatal("expected snapshot to be unaffected by deregister")
+	}
+}
+
+func TestAliases(t *testing.T) {
+	var registry SyncRegistry
+	for _, r := range []*Registration{
+		{Type: "grpc", ID: "cri", Requires: []Type{"cri.runtime"}},
+		{Type: "cri", ID: "cri-runtime", Aliases: []string{"runtime"}},
+	} {
+		if err := registry.Register(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmpOrderedRefs(t, registry.Snapshot().GraphRefs(mockPluginFilter), []string{"cri.cri-runtime", "grpc.cri"})
+	if err := registry.Register(&Registration{Type: "cri", ID: "runtime"}); !errors.Is(err, ErrIDRegistered) {
+		t.Fatalf("expected alias conflict, got %v", err)
+	}
+	if err := registry.Register(&Registration{Type: "grpc", ID: "v1", Aliases: []string{"grpc.cri"}}); !errors.Is(err, ErrIDRegistered) {
+		t.Fatalf("expected alias conflict, got %v", err)
+	}
+
+	var deprecated []string
+	plugins := NewPluginSet(WithAliasHandler(func(alias, uri string) {
+		deprecated = append(deprecated, alias+" -> "+uri)
+	}))
+	p := testPlugin("cri", "cri-runtime", "runtime", nil)
+	p.Registration.Aliases = []string{"runtime"}
+	if err := plugins.Add(p); err != nil {
+		t.Fatal(err)
+	}
+	ic := InitContext{plugins: plugins}
+	if instance, err := ic.GetByID("cri", "runtime"); err != nil || instance != "runtime" {
+		t.Fatalf("expected lookup by alias, got %v, %v", instance, err)
+	}
+	if fmt.Sprint(deprecated) != "[cri.runtime -> cri.cri-runtime]" {
+		t.Fatalf("unexpected deprecation warnings %v", deprecated)
+	}
+}
+
+func TestValidators(t *testing.T) {
+	var (
+		errForbidden   = errors.New("forbidden dependency")
+		errInvalidType = errors.New("invalid type")
+	)
+	noGRPC := func(r *Registration) error {
+		if r.Type != "grpc" {
+			for _, req := range r.Requires {
+				if req == "grpc" {
+					return errForbidden
+				}
+			}
+		}
+		return nil
+	}
+	prefixed := func(r *Registration) error {
+		if !strings.HasPrefix(string(r.Type), "io.containerd.") {
+			return fmt.Errorf("type %s: %w", r.Type, errInvalidType)
+		}
+		return nil
+	}
+
+	var registry SyncRegistry
+	registry.AddValidator(noGRPC)
+	if err := registry.Register(&Registration{Type: "content", ID: "local", Requires: []Type{"grpc"}}); !errors.Is(err, errForbidden) {
+		t.Fatalf("expected forbidden dependency, got %v", err)
+	}
+	if err := registry.Register(&Registration{Type: "content", ID: "local"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Replace(&Registration{Type: "content", ID: "local", Requires: []Type{"grpc"}}); !errors.Is(err, errForbidden) {
+		t.Fatalf("expected forbidden dependency, got %v", err)
+	}
+
+	err := registry.Snapshot().Register(&Registration{Type: "io.containerd.content.v1", ID: "local"}).Check(noGRPC, prefixed)
+	if !errors.Is(err, errInvalidType) || !strings.HasPrefix(err.Error(), "content.local: ") {
+		t.Fatalf("expected invalid type for content.local, got %v", err)
+	}
+}
+
+func TestDefaultInstance(t *testing.T) {
+	plugins := NewPluginSet()
+	overlay := testPlugin("snapshotter", "overlayfs", "overlayfs", nil)
+	for _, p := range []*Plugin{
+		testPlugin("snapshotter", "native", "native", nil),
+		overlay,
+		testPlugin("snapshotter", "btrfs", nil, ErrSkipPlugin),
+	} {
+		if err := plugins.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ic := InitContext{plugins: plugins}
+	if _, err := ic.GetSingle("snapshotter"); !errors.Is(err, ErrPluginMultipleInstances) {
+		t.Fatalf("expected multiple instances, got %v", err)
+	}
+	overlay.Registration.Default = true
+	if instance, err := ic.GetSingle("snapshotter"); err != nil || instance != "overlayfs" {
+		t.Fatalf("expected default instance, got %v, %v", instance, err)
+	}
+
+	defer func() {
+		if err, ok := recover().(error); !ok || !errors.Is(err, ErrPluginMultipleInstances) {
+			t.Fatalf("expected multiple defaults panic, got %v", err)
+		}
+	}()
+	var registry Registry
+	registry.Register(&Registration{Type: "snapshotter", ID: "overlayfs", Default: true}).
+		Register(&Registration{Type: "snapshotter", ID: "native", Default: true})
+}
+
+func TestNewRegistration(t *testing.T) {
+	type config struct{ Root string }
+	r := NewRegistration("snapshotter", "overlayfs", func(*InitContext) (interface{}, error) { return "overlayfs", nil },
+		WithRequires("content"),
+		WithRequires("metadata"),
+		WithOptional("diff"),
+		WithConfig(&config{Root: "/var/lib"}),
+		WithPlatforms([]string{"linux"}, nil),
+	)
+	if r.URI() != "snapshotter.overlayfs" || fmt.Sprint(r.Requires) != "[content metadata]" || fmt.Sprint(r.Optional) != "[diff]" {
+		t.Fatalf("unexpected registration %+v", r)
+	}
+	if c, ok := r.Config.(*config); !ok || c.Root != "/var/lib" {
+		t.Fatalf("unexpected config %v", r.Config)
+	}
+	if !r.SupportsPlatform("linux", "arm64") || r.SupportsPlatform("windows", "amd64") {
+		t.Fatal("expected plugin to support only linux")
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	initFn := func(*InitContext) (interface{}, error) { return nil, nil }
+	var registry Registry
+	if err := Build("content", "local").Init(initFn).Register(&registry); err != nil {
+		t.Fatal(err)
+	}
+	if err := Build("metadata", "bolt").Requires("content").Config(&struct{}{}).Init(initFn).Register(&registry); err != nil {
+		t.Fatal(err)
+	}
+	cmpOrderedRefs(t, registry.GraphRefs(mockPluginFilter), []string{"content.local", "metadata.bolt"})
+
+	for _, tc := range []struct {
+		b   *Builder
+		err error
+	}{
+		{Build("", "local").Init(initFn), ErrNoType},
+		{Build("grpc", "tasks").Requires("content", "*").Init(initFn), ErrInvalidRequires},
+		{Build("grpc", "tasks").Requires("content >= one").Init(initFn), ErrInvalidRequires},
+		{Build("grpc", "tasks").Optional("*", "content").Init(initFn), ErrInvalidOptional},
+		{Build("content", "local").Init(initFn), ErrIDRegistered},
+	} {
+		if err := tc.b.Register(&registry); !errors.Is(err, tc.err) {
+			t.Errorf("expected %v, got %v", tc.err, err)
+		}
+	}
+	if _, err := Build("grpc", "tasks").Registration(); err == nil {
+		t.Fatal("expected error for missing init function")
+	}
+	if len(registry) != 2 {
+		t.Fatalf("expected invalid registrations to be rejected, got %d", len(registry))
+	}
+}
+
+type testSnapshotter interface {
+	Root() string
+}
+
+type testOverlay struct{}
+
+func (testOverlay) Root() string { return "/var/lib/overlayfs" }
+
+func TestTypedRegistration(t *testing.T) {
+	var registry Registry
+	RegisterTyped(&registry, TypedRegistration[testSnapshotter]{
+		Registration: Registration{Type: "snapshotter", ID: "overlayfs"},
+		InitFn: func(*InitContext) (testSnapshotter, error) {
+			return testOverlay{}, nil
+		},
+	})
+	RegisterTyped(&registry, TypedRegistration[string]{
+		Registration: Registration{Type: "content", ID: "local"},
+		InitFn: func(*InitContext) (string, error) {
+			return "local", nil
+		},
+	})
+
+	plugins := NewPluginSet()
+	for _, r := range registry.GraphRefs(mockPluginFilter) {
+		if err := plugins.Add(r.Init(NewContext(context.Background(), plugins, nil))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ic := NewContext(context.Background(), plugins, nil)
+	sn, err := Get[testSnapshotter](ic, "snapshotter")
+	if err != nil || sn.Root() != "/var/lib/overlayfs" {
+		t.Fatalf("unexpected snapshotter %v, %v", sn, err)
+	}
+	if _, err := Get[testSnapshotter](ic, "content"); err == nil || !strings.Contains(err.Error(), "not plugin.testSnapshotter") {
+		t.Fatalf("expected type mismatch, got %v", err)
+	}
+	if _, err := Get[string](ic, "differ"); !errors.Is(err, ErrPluginNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	if s, err := GetByIDAs[string](ic, "content", "local"); err != nil || s != "local" {
+		t.Fatalf("unexpected content %q, %v", s, err)
+	}
+	_, err = GetByIDAs[testSnapshotter](ic, "content", "local")
+	var typeErr *InstanceTypeError
+	if !errors.As(err, &typeErr) || !errors.Is(err, ErrInstanceType) {
+		t.Fatalf("expected instance type error, got %v", err)
+	}
+	if typeErr.Plugin != "content.local" || typeErr.Expected != "plugin.testSnapshotter" || typeErr.Actual != "string" {
+		t.Fatalf("unexpected instance type error %+v", typeErr)
+	}
+	if _, err := GetByIDAs[string](ic, "content", "remote"); !errors.Is(err, ErrPluginNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+}
+
+func TestMustGet(t *testing.T) {
+	plugins := NewPluginSet()
+	for _, p := range []*Plugin{
+		testPlugin("snapshotter", "btrfs", nil, ErrSkipPlugin),
+		testPlugin("snapshotter", "zfs", nil, errors.New("zpool unavailable")),
+		testPlugin("content", "local", "local", nil),
+	} {
+		if err := plugins.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ic := &InitContext{
+		plugins:      plugins,
+		registration: &Registration{Type: "service", ID: "images"},
+	}
+	if i := ic.MustGetSingle("content"); i != "local" {
+		t.Fatalf("unexpected content instance %v", i)
+	}
+	if i := ic.MustGetByID("content", "local"); i != "local" {
+		t.Fatalf("unexpected content instance %v", i)
+	}
+
+	mustPanic := func(fn func()) (err *DependencyError) {
+		t.Helper()
+		defer func() {
+			var ok bool
+			if err, ok = recover().(*DependencyError); !ok {
+				t.Fatalf("expected dependency error panic, got %v", err)
+			}
+		}()
+		fn()
+		return nil
+	}
+	err := mustPanic(func() { ic.MustGetSingle("snapshotter") })
+	expected := &DependencyError{
+		Plugin:     "service.images",
+		Dependency: "snapshotter",
+		Candidates: []string{"snapshotter.btrfs (skipped)", "snapshotter.zfs (failed)"},
+	}
+	if err.Plugin != expected.Plugin || err.Dependency != expected.Dependency || !reflect.DeepEqual(err.Candidates, expected.Candidates) {
+		t.Fatalf("unexpected dependency error %+v", err)
+	}
+	if !strings.Contains(err.Error(), "zpool unavailable") {
+		t.Fatalf("expected lookup error in %q", err)
+	}
+
+	err = mustPanic(func() { ic.MustGetByID("diff", "walking") })
+	if err.Dependency != "diff.walking" || len(err.Candidates) != 0 || !errors.Is(err, ErrPluginNotFound) {
+		t.Fatalf("unexpected dependency error %+v", err)
+	}
+	if msg := err.Error(); msg != "service.images: dependency diff.walking unavailable, no candidates: no plugins registered for diff.walking: plugin: not found" {
+		t.Fatalf("unexpected error message %q", msg)
+	}
+
+	// panics other than a DependencyError are not recovered by Init
+	defer func() {
+		if v := recover(); v != "unexpected" {
+			t.Fatalf("expected panic to propagate, got %v", v)
+		}
+	}()
+	Registration{Type: "service", ID: "panics", InitFn: func(*InitContext) (interface{}, error) {
+		panic("unexpected")
+	}}.Init(NewContext(context.Background(), plugins, nil))
+	t.Fatal("expected panic")
+}
+
+func TestSealedRegistry(t *testing.T) {
+	var registry SyncRegistry
+	if err := registry.Register(&Registration{Type: "content", ID: "local"}); err != nil {
+		t.Fatal(err)
+	}
+	registry.Seal()
+	if !registry.Sealed() {
+		t.Fatal("expected registry to be sealed")
+	}
+	if err := registry.Register(&Registration{Type: "snapshotter", ID: "overlayfs"}); !errors.Is(err, ErrRegistrySealed) {
+		t.Fatalf("expected sealed error, got %v", err)
+	}
+	if err := registry.Replace(&Registration{Type: "content", ID: "local"}); !errors.Is(err, ErrRegistrySealed) {
+		t.Fatalf("expected sealed error, got %v", err)
+	}
+	if registry.Deregister("content", "local") || len(registry.Snapshot()) != 1 {
+		t.Fatal("expected sealed registry to be unchanged")
+	}
+}
+
+func TestRegisterBundle(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "content", ID: "local"})
+	registry = registry.RegisterBundle("cri",
+		&Registration{Type: "cri", ID: "images", Requires: []Type{"content"}},
+		&Registration{Type: "grpc", ID: "cri", Requires: []Type{"cri"}},
+	)
+	cmpOrderedRefs(t, registry.Bundle("cri"), []string{"cri.images", "grpc.cri"})
+
+	var shared SyncRegistry
+	for _, r := range registry {
+		if err := shared.Register(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := shared.RegisterBundle("sandbox",
+		&Registration{Type: "sandbox", ID: "controller"},
+		&Registration{Type: "content", ID: "local"},
+	)
+	if !errors.Is(err, ErrIDRegistered) {
+		t.Fatalf("expected duplicate error, got %v", err)
+	}
+	if snapshot := shared.Snapshot(); len(snapshot) != 3 || len(snapshot.Bundle("sandbox")) != 0 {
+		t.Fatalf("expected bundle not to be registered, got %d registrations", len(snapshot))
+	}
+}
+
+func TestTypeParse(t *testing.T) {
+	typ, err := NewType("io.containerd", "snapshotter", 1)
+	if err != nil || typ != "io.containerd.snapshotter.v1" {
+		t.Fatalf("unexpected type %q, %v", typ, err)
+	}
+	namespace, kind, version, err := Type("io.containerd.content.v12").Parse()
+	if err != nil || namespace != "io.containerd" || kind != "content" || version != 12 {
+		t.Fatalf("unexpected parse %q %q %d, %v", namespace, kind, version, err)
+	}
+	for _, invalid := range []Type{"snapshotter", "snapshotter.v1", "io.containerd.snapshotter", "io.containerd.snapshotter.v0", "io..snapshotter.v1", "io.containerd.Snapshotter.v1"} {
+		if _, _, _, err := invalid.Parse(); !errors.Is(err, ErrInvalidType) {
+			t.Errorf("expected %q to be invalid, got %v", invalid, err)
+		}
+	}
+	for _, invalid := range []struct {
+		namespace, kind string
+		version         int
+	}{
+		{"", "snapshotter", 1},
+		{"io.containerd", "snap.shotter", 1},
+		{"io.containerd", "snapshotter", 0},
+	} {
+		if _, err := NewType(invalid.namespace, invalid.kind, invalid.version); !errors.Is(err, ErrInvalidType) {
+			t.Errorf("expected %+v to be invalid, got %v", invalid, err)
+		}
+	}
+}
+
+func TestDisablePatterns(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "io.containerd.grpc.v1", ID: "tasks"}).
+		Register(&Registration{Type: "io.containerd.snapshotter.v1", ID: "overlayfs"}).
+		Register(&Registration{Type: "io.containerd.snapshotter.v1", ID: "zfs"}).
+		Register(&Registration{Type: "io.containerd.content.v1", ID: "content"})
+
+	filter, err := DisableGlobs("io.containerd.grpc.v1.*", "*.zfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmpOrderedRefs(t, registry.GraphRefs(filter), []string{"io.containerd.snapshotter.v1.overlayfs", "io.containerd.content.v1.content"})
+
+	filter, err = DisableRegexps(`snapshotter\.v1\.(zfs|btrfs)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmpOrderedRefs(t, registry.GraphRefs(filter), []string{"io.containerd.grpc.v1.tasks", "io.containerd.snapshotter.v1.overlayfs", "io.containerd.content.v1.content"})
+
+	if _, err := DisableGlobs("io.containerd.[grpc"); err == nil {
+		t.Error("expected invalid glob")
+	}
+	if _, err := DisableRegexps("snapshotter.(zfs"); err == nil {
+		t.Error("expected invalid regexp")
+	}
+}
+
+func TestFilterCombinators(t *testing.T) {
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "snapshotter", ID: "overlayfs"}).
+		Register(&Registration{Type: "snapshotter", ID: "erofs", Labels: map[string]string{"experimental": ""}}).
+		Register(&Registration{Type: "content", ID: "local"}).
+		Register(&Registration{Type: "grpc", ID: "tasks", Labels: map[string]string{"experimental": ""}})
+
+	experimental, err := DisableLabels("experimental")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshotters := func(r *Registration) bool { return r.Type == "snapshotter" }
+
+	cmpOrderedRefs(t, registry.GraphRefs(FilterOr(experimental, snapshotters)), []string{"content.local"})
+	cmpOrderedRefs(t, registry.GraphRefs(FilterAnd(experimental, snapshotters)), []string{"snapshotter.overlayfs", "content.local", "grpc.tasks"})
+	cmpOrderedRefs(t, registry.GraphRefs(FilterNot(experimental)), []string{"snapshotter.erofs", "grpc.tasks"})
+	cmpOrderedRefs(t, registry.GraphRefs(FilterAnd()), nil)
+	cmpOrderedRefs(t, registry.GraphRefs(FilterOr()), []string{"snapshotter.overlayfs", "snapshotter.erofs", "content.local", "grpc.tasks"})
+}
@@ -0,0 +1,196 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package plugintest provides helpers for testing plugin registrations.
+package plugintest
+
+import (
+	"bytes"
+	"go/format"
+	"io"
+	"sort"
+	"text/template"
+
+	"github.com/containerd/plugin"
+)
+
+// ContractOpts configures the generated contract tests
+type ContractOpts struct {
+	// Package is the package name of the generated test file
+	Package string
+	// Imports are additional import paths needed by the type assertions
+	Imports []string
+	// Assertions maps a plugin type to the Go type its instances must
+	// satisfy, such as "snapshots.Snapshotter".
+	Assertions map[plugin.Type]string
+}
+
+type contract struct {
+	URI      string
+	Type     plugin.Type
+	ID       string
+	Requires []string
+	Assert   string
+}
+
+var contractTemplate = template.Must(template.New("contract").Parse(`// Code generated by plugintest.GenerateContractTests. DO NOT EDIT.
+
+package {{ .Package }}
+
+import (
+{{- if .HasAssertions }}
+	"context"
+{{- end }}
+	"testing"
+
+	"github.com/containerd/plugin"
+	"github.com/containerd/plugin/registry"
+{{- range .Imports }}
+	{{ printf "%q" . }}
+{{- end }}
+)
+
+var pluginContracts = []struct {
+	uri      string
+	requires []string
+}{
+{{- range .Contracts }}
+	{ {{ printf "%q" .URI }}, []string{ {{- range .Requires }}{{ printf "%q" . }}, {{ end -}} } },
+{{- end }}
+}
+
+// TestPluginContracts asserts every plugin is registered and ordered after
+// the plugins it depends on.
+func TestPluginContracts(t *testing.T) {
+	position := map[string]int{}
+	for i, r := range registry.Graph(func(*plugin.Registration) bool { return false }) {
+		position[r.URI()] = i
+	}
+	for _, tc := range pluginContracts {
+		tc := tc
+		t.Run(tc.uri, func(t *testing.T) {
+			i, ok := position[tc.uri]
+			if !ok {
+				t.Fatalf("plugin %s is not registered", tc.uri)
+			}
+			for _, dep := range tc.requires {
+				j, ok := position[dep]
+				if !ok {
+					t.Errorf("dependency %s of %s is not registered", dep, tc.uri)
+				} else if j > i {
+					t.Errorf("dependency %s is initialized after %s", dep, tc.uri)
+				}
+			}
+		})
+	}
+}
+
+{{- if .HasAssertions }}
+
+// TestPluginInstances initializes the registered plugins and asserts the
+// instances of the loaded plugins implement the expected types. Plugins
+// which fail or skip initialization in the test environment are ignored.
+func TestPluginInstances(t *testing.T) {
+	ctx := context.Background()
+	res, err := plugin.NewLoader(
+		plugin.Registry(registry.GraphRefs(func(*plugin.Registration) bool { return false })),
+		plugin.WithLoadProperties(map[string]string{
+			plugin.PropertyRootDir:  t.TempDir(),
+			plugin.PropertyStateDir: t.TempDir(),
+		}),
+	).Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Plugins.Shutdown(ctx)
+	checkPluginInstances(t, res.Plugins)
+}
+
+// checkPluginInstances asserts the instances in an initialized plugin set
+// implement the expected types.
+func checkPluginInstances(t *testing.T, ps *plugin.Set) {
+	t.Helper()
+{{- range .Contracts }}{{ if .Assert }}
+	if p := ps.Get({{ printf "%q" .Type }}, {{ printf "%q" .ID }}); p != nil {
+		if i, err := p.Instance(); err == nil {
+			if _, ok := i.({{ .Assert }}); !ok {
+				t.Errorf("plugin %s instance %T does not implement %s", {{ printf "%q" .URI }}, i, {{ printf "%q" .Assert }})
+			}
+		}
+	}
+{{- end }}{{ end }}
+}
+{{- end }}
+`))
+
+// contractEdges are the kinds of edges to plugins which must be present and
+// initialized before a plugin for it to be wired correctly
+var contractEdges = map[plugin.EdgeKind]bool{
+	plugin.EdgeRequires:  true,
+	plugin.EdgeOptional:  true,
+	plugin.EdgeNeeds:     true,
+	plugin.EdgeConfigFor: true,
+}
+
+// GenerateContractTests writes a Go test file asserting the presence and
+// ordering of each registration's dependencies, as resolved by
+// Registry.DependencyGraph. When assertions are given, the file also
+// initializes the plugins and checks the types of their instances.
+// Downstream builds commit the generated file to catch wiring regressions
+// when updating plugins.
+func GenerateContractTests(w io.Writer, opts ContractOpts, registry plugin.Registry) error {
+	all := func(*plugin.Registration) bool { return false }
+	if err := registry.Validate(all); err != nil {
+		return err
+	}
+	graph := registry.DependencyGraph(all)
+	var contracts []contract
+	for _, r := range registry {
+		c := contract{
+			URI:    r.URI(),
+			Type:   r.Type,
+			ID:     r.ID,
+			Assert: opts.Assertions[r.Type],
+		}
+		seen := map[string]bool{}
+		for _, e := range graph.Dependencies(c.URI) {
+			if contractEdges[e.Kind] && !seen[e.Dependency] {
+				seen[e.Dependency] = true
+				c.Requires = append(c.Requires, e.Dependency)
+			}
+		}
+		sort.Strings(c.Requires)
+		contracts = append(contracts, c)
+	}
+	sort.Slice(contracts, func(i, j int) bool {
+		return contracts[i].URI < contracts[j].URI
+	})
+
+	var buf bytes.Buffer
+	if err := contractTemplate.Execute(&buf, struct {
+		ContractOpts
+		Contracts     []contract
+		HasAssertions bool
+	}{opts, contracts, len(opts.Assertions) > 0}); err != nil {
+		return err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
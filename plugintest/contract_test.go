@@ -0,0 +1,87 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugintest
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/containerd/plugin"
+)
+
+func TestGenerateContractTests(t *testing.T) {
+	var registry plugin.Registry
+	registry = registry.Register(&plugin.Registration{
+		Type: "content",
+		ID:   "local",
+	}).Register(&plugin.Registration{
+		Type:     "metadata",
+		ID:       "bolt",
+		Requires: []plugin.Type{"content"},
+	}).Register(&plugin.Registration{
+		Type:      "warmup",
+		ID:        "defaults",
+		ConfigFor: []plugin.Type{"gc.scheduler"},
+	}).Register(&plugin.Registration{
+		Type:     "gc",
+		ID:       "scheduler",
+		Provides: []string{"gc"},
+		Barrier:  []plugin.Type{"content"},
+	}).Register(&plugin.Registration{
+		Type:  "service",
+		ID:    "gc",
+		Needs: []string{"gc"},
+	})
+
+	var buf bytes.Buffer
+	err := GenerateContractTests(&buf, ContractOpts{
+		Package:    "builtins",
+		Imports:    []string{"io"},
+		Assertions: map[plugin.Type]string{"content": "io.Closer"},
+	}, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := buf.String()
+	if _, err := parser.ParseFile(token.NewFileSet(), "contract_test.go", src, 0); err != nil {
+		t.Fatalf("generated invalid source: %v\n%s", err, src)
+	}
+	for _, expected := range []string{
+		"package builtins",
+		`{"metadata.bolt", []string{"content.local"}}`,
+		`{"gc.scheduler", []string{"warmup.defaults"}}`,
+		`{"service.gc", []string{"gc.scheduler"}}`,
+		`i.(io.Closer)`,
+		"func TestPluginInstances(t *testing.T) {",
+		"checkPluginInstances(t, res.Plugins)",
+	} {
+		if !strings.Contains(src, expected) {
+			t.Errorf("expected generated source to contain %q\n%s", expected, src)
+		}
+	}
+
+	buf.Reset()
+	if err := GenerateContractTests(&buf, ContractOpts{Package: "builtins"}, registry); err != nil {
+		t.Fatal(err)
+	}
+	if src := buf.String(); strings.Contains(src, "checkPluginInstances") || strings.Contains(src, `"context"`) {
+		t.Fatalf("expected no instance checks without assertions\n%s", src)
+	}
+}
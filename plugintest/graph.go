@@ -0,0 +1,115 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugintest
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containerd/plugin"
+)
+
+// ParseGraph builds a registry from a compact description of plugins and
+// their dependencies, such as
+//
+//	metadata.bolt -> content.content, snapshotter.overlayfs; service.x -> metadata
+//
+// Statements are separated by semicolons. Each statement declares a plugin
+// URI optionally followed by "->" and a comma separated list of the entries
+// it requires. Dependencies containing a dot are plugin URIs and are also
+// declared as plugins, other dependencies are plugin types. Plugins are
+// registered in order of first appearance with an init function returning
+// no instance.
+func ParseGraph(s string) (plugin.Registry, error) {
+	var (
+		order    []string
+		requires = map[string][]plugin.Type{}
+	)
+	declare := func(uri string) error {
+		if _, ok := requires[uri]; ok {
+			return nil
+		}
+		if i := strings.LastIndexByte(uri, '.'); i <= 0 || i == len(uri)-1 {
+			return fmt.Errorf("invalid plugin URI %q", uri)
+		}
+		order = append(order, uri)
+		requires[uri] = nil
+		return nil
+	}
+	for _, stmt := range strings.Split(s, ";") {
+		stmt = strings.TrimSpace(stmt)
+		if stmt == "" {
+			continue
+		}
+		uri, deps, _ := strings.Cut(stmt, "->")
+		uri = strings.TrimSpace(uri)
+		if err := declare(uri); err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(deps) == "" {
+			continue
+		}
+		for _, dep := range strings.Split(deps, ",") {
+			dep = strings.TrimSpace(dep)
+			if dep == "" {
+				return nil, fmt.Errorf("empty dependency of %s", uri)
+			}
+			if strings.Contains(dep, ".") {
+				if err := declare(dep); err != nil {
+					return nil, err
+				}
+			}
+			requires[uri] = append(requires[uri], plugin.Type(dep))
+		}
+	}
+
+	return register(order, requires)
+}
+
+// register registers the parsed plugins, returning the registration panics
+// for invalid dependencies as errors.
+func register(order []string, requires map[string][]plugin.Type) (registry plugin.Registry, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			var ok bool
+			if err, ok = v.(error); !ok {
+				panic(v)
+			}
+		}
+	}()
+	for _, uri := range order {
+		i := strings.LastIndexByte(uri, '.')
+		registry = registry.Register(&plugin.Registration{
+			Type:     plugin.Type(uri[:i]),
+			ID:       uri[i+1:],
+			Requires: requires[uri],
+			InitFn: func(*plugin.InitContext) (interface{}, error) {
+				return nil, nil
+			},
+		})
+	}
+	return registry, nil
+}
+
+// MustParseGraph is like ParseGraph but panics if the description is invalid
+func MustParseGraph(s string) plugin.Registry {
+	registry, err := ParseGraph(s)
+	if err != nil {
+		panic(err)
+	}
+	return registry
+}
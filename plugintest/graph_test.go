@@ -0,0 +1,49 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugintest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/containerd/plugin"
+)
+
+func TestParseGraph(t *testing.T) {
+	registry, err := ParseGraph("service.x -> metadata; metadata.bolt -> content.content, snapshotter.overlayfs; grpc.introspection -> *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uris []string
+	for _, r := range registry.GraphRefs(func(*plugin.Registration) bool { return false }) {
+		uris = append(uris, r.URI())
+	}
+	expected := "[content.content snapshotter.overlayfs metadata.bolt service.x grpc.introspection]"
+	if fmt.Sprint(uris) != expected {
+		t.Fatalf("unexpected order %v, expected %s", uris, expected)
+	}
+
+	for _, invalid := range []string{"metadata", "a.b -> c.", "a.b -> ,c", "a.b -> *, c"} {
+		if _, err := ParseGraph(invalid); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+	if _, err := ParseGraph("a.b -> *, c"); !errors.Is(err, plugin.ErrInvalidRequires) {
+		t.Errorf("expected invalid requires, got %v", err)
+	}
+}
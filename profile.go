@@ -0,0 +1,72 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+)
+
+// WithInitProfiling enables collecting a CPU and heap profile around the
+// plugin's InitFn. Profiles are written to dir as <uri>.cpu.pprof and
+// <uri>.heap.pprof and samples are labeled with the plugin URI. Failures to
+// collect profiles are logged and do not affect initialization.
+//
+// CPU profiling is process wide, plugins should be initialized serially
+// when profiling is enabled.
+func WithInitProfiling(dir string) InitContextOpt {
+	return func(ic *InitContext) {
+		ic.profileDir = dir
+	}
+}
+
+// profileInit calls the init function while collecting profiles
+func profileInit(ic *InitContext, r *Registration) (instance interface{}, err error) {
+	uri := r.URI()
+	cpu, perr := os.Create(filepath.Join(ic.profileDir, uri+".cpu.pprof"))
+	if perr != nil {
+		ic.Logger.Warn("failed to create cpu profile", "error", perr)
+	} else {
+		defer cpu.Close()
+		if perr := pprof.StartCPUProfile(cpu); perr != nil {
+			ic.Logger.Warn("failed to start cpu profile", "error", perr)
+		} else {
+			defer pprof.StopCPUProfile()
+		}
+	}
+
+	ctx := ic.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	pprof.Do(ctx, pprof.Labels("plugin", uri), func(context.Context) {
+		instance, err = r.InitFn(ic)
+	})
+
+	heap, perr := os.Create(filepath.Join(ic.profileDir, uri+".heap.pprof"))
+	if perr != nil {
+		ic.Logger.Warn("failed to create heap profile", "error", perr)
+		return
+	}
+	defer heap.Close()
+	if perr := pprof.WriteHeapProfile(heap); perr != nil {
+		ic.Logger.Warn("failed to write heap profile", "error", perr)
+	}
+	return
+}
@@ -0,0 +1,71 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Well-known InitContext properties set by the daemon for every plugin
+const (
+	// PropertyRootDir is the root directory of the plugin for persistent data
+	PropertyRootDir = "io.containerd.plugin.root"
+	// PropertyStateDir is the state directory of the plugin for data which
+	// does not persist across reboots
+	PropertyStateDir = "io.containerd.plugin.state"
+	// PropertyGRPCAddress is the address of the daemon's GRPC server
+	PropertyGRPCAddress = "io.containerd.plugin.grpc.address"
+	// PropertyTTRPCAddress is the address of the daemon's TTRPC server
+	PropertyTTRPCAddress = "io.containerd.plugin.ttrpc.address"
+)
+
+// ErrMissingProperty is returned when a required InitContext property is not
+// set by the daemon
+var ErrMissingProperty = errors.New("plugin: missing property")
+
+// Property returns the value of the property, returning an error wrapping
+// ErrMissingProperty if it is not set or empty
+func (i *InitContext) Property(key string) (string, error) {
+	if v := i.Properties[key]; v != "" {
+		return v, nil
+	}
+	if i.registration != nil {
+		return "", fmt.Errorf("%s requires property %q: %w", i.registration.URI(), key, ErrMissingProperty)
+	}
+	return "", fmt.Errorf("property %q: %w", key, ErrMissingProperty)
+}
+
+// RootDir returns the PropertyRootDir property
+func (i *InitContext) RootDir() (string, error) {
+	return i.Property(PropertyRootDir)
+}
+
+// StateDir returns the PropertyStateDir property
+func (i *InitContext) StateDir() (string, error) {
+	return i.Property(PropertyStateDir)
+}
+
+// GRPCAddress returns the PropertyGRPCAddress property
+func (i *InitContext) GRPCAddress() (string, error) {
+	return i.Property(PropertyGRPCAddress)
+}
+
+// TTRPCAddress returns the PropertyTTRPCAddress property
+func (i *InitContext) TTRPCAddress() (string, error) {
+	return i.Property(PropertyTTRPCAddress)
+}
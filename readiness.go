@@ -0,0 +1,59 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrReadinessSourceNotFound is used when a plugin waits for a readiness
+// source which was not provided.
+var ErrReadinessSourceNotFound = errors.New("plugin: readiness source not found")
+
+// ReadinessSource blocks until an external condition of the host is met,
+// such as the network being online or a device plugin socket being present.
+// A source which is not ready in time should return an error, wrapping
+// ErrSkipPlugin to skip the waiting plugins rather than failing them.
+type ReadinessSource func(context.Context) error
+
+// WithReadinessSources provides the readiness sources plugins may wait for
+// by name in their registration's WaitFor.
+func WithReadinessSources(sources map[string]ReadinessSource) InitContextOpt {
+	return func(ic *InitContext) {
+		ic.readiness = sources
+	}
+}
+
+// waitFor waits for each readiness source of the registration in order
+func (i *InitContext) waitFor(r *Registration) error {
+	ctx := i.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for _, name := range r.WaitFor {
+		source, ok := i.readiness[name]
+		if !ok {
+			return fmt.Errorf("%s waits for %q: %w", r.URI(), name, ErrReadinessSourceNotFound)
+		}
+		if err := source(ctx); err != nil {
+			return fmt.Errorf("%s waiting for %q: %w", r.URI(), name, err)
+		}
+	}
+	return nil
+}
@@ -17,6 +17,7 @@
 package registry
 
 import (
+	"fmt"
 	"sync"
 
 	"github.com/containerd/plugin"
@@ -24,27 +25,182 @@ import (
 
 var register = struct {
 	sync.RWMutex
-	r plugin.Registry
+	r          plugin.Registry
+	tombstones []plugin.Tombstone
+	validators []plugin.Validator
+	sealed     bool
 }{}
 
+// Seal marks the global registry as final, later calls to Register, Replace
+// and Deregister panic with plugin.ErrRegistrySealed. Hosts seal the
+// registry once the daemon has started so a late package init function
+// cannot change the registered plugins.
+func Seal() {
+	register.Lock()
+	defer register.Unlock()
+	register.sealed = true
+}
+
+func checkSealed(op string, t plugin.Type, id string) {
+	if register.sealed {
+		panic(fmt.Errorf("%s %s: %w", op, plugin.Instance(t, id), plugin.ErrRegistrySealed))
+	}
+}
+
 // Register allows plugins to register
 func Register(r *plugin.Registration) {
 	register.Lock()
 	defer register.Unlock()
+	checkSealed("register", r.Type, r.ID)
+	checkRegistration(r)
 	register.r = register.r.Register(r)
 }
 
-// Reset removes all global registrations
+// RegisterBundle registers a group of plugins atomically, see
+// plugin.Registry.RegisterBundle
+func RegisterBundle(name string, regs ...*plugin.Registration) {
+	register.Lock()
+	defer register.Unlock()
+	for _, r := range regs {
+		checkSealed("register", r.Type, r.ID)
+		checkRegistration(r)
+	}
+	register.r = register.r.RegisterBundle(name, regs...)
+}
+
+// Replace substitutes the registered plugin with the same URI
+func Replace(r *plugin.Registration) {
+	register.Lock()
+	defer register.Unlock()
+	checkSealed("replace", r.Type, r.ID)
+	checkRegistration(r)
+	register.r = register.r.Replace(r)
+}
+
+// Deregister removes the registered plugin with the given type and ID
+func Deregister(t plugin.Type, id string) {
+	register.Lock()
+	defer register.Unlock()
+	checkSealed("deregister", t, id)
+	register.r = register.r.Deregister(t, id)
+}
+
+// Reset removes all global registrations, tombstones and validators and
+// unseals the registry
 func Reset() {
 	register.Lock()
 	defer register.Unlock()
+	register.sealed = false
 	register.r = nil
+	register.tombstones = nil
+	register.validators = nil
+}
+
+// AddValidator adds a validator run against every later registration,
+// Register and Replace panic with the error of a rejected registration.
+// Plugins registered before the validator was added, such as from package
+// init functions, are checked by Check.
+func AddValidator(v plugin.Validator) {
+	register.Lock()
+	defer register.Unlock()
+	register.validators = append(register.validators, v)
+}
+
+// Check runs the added validators against every registered plugin
+func Check() error {
+	register.RLock()
+	defer register.RUnlock()
+	return register.r.Check(register.validators...)
+}
+
+func checkRegistration(r *plugin.Registration) {
+	if err := (plugin.Registry{r}).Check(register.validators...); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterTombstone records plugin types which were removed or renamed
+func RegisterTombstone(tombstones ...plugin.Tombstone) {
+	register.Lock()
+	defer register.Unlock()
+	register.tombstones = append(register.tombstones, tombstones...)
+}
+
+// CheckTombstones returns an error naming the replacement type for every
+// registered plugin depending on a type with a registered tombstone.
+func CheckTombstones() error {
+	register.RLock()
+	defer register.RUnlock()
+	return register.r.CheckTombstones(register.tombstones)
 }
 
 // Graph returns an ordered list of registered plugins for initialization.
 // Plugins in disableList specified by id will be disabled.
-func Graph(filter plugin.DisableFilter) []plugin.Registration {
+func Graph(filter plugin.DisableFilter, opts ...plugin.GraphOpt) []plugin.Registration {
+	register.RLock()
+	defer register.RUnlock()
+	return register.r.Graph(filter, opts...)
+}
+
+// GraphE returns the same ordered list as Graph, returning an error rather
+// than panicking when the graph cannot be computed.
+func GraphE(filter plugin.DisableFilter, opts ...plugin.GraphOpt) ([]plugin.Registration, error) {
+	register.RLock()
+	defer register.RUnlock()
+	return register.r.GraphE(filter, opts...)
+}
+
+// GraphRefs returns the same ordered list as Graph without copying the
+// registrations. The returned registrations must be treated as read-only.
+func GraphRefs(filter plugin.DisableFilter, opts ...plugin.GraphOpt) []*plugin.Registration {
+	register.RLock()
+	defer register.RUnlock()
+	return register.r.GraphRefs(filter, opts...)
+}
+
+// Resolve returns the ordered list of registered plugins along with the
+// plugins disabled by the filter.
+func Resolve(filter plugin.DisableFilter, opts ...plugin.GraphOpt) *plugin.RegistrationGraph {
+	register.RLock()
+	defer register.RUnlock()
+	return register.r.Resolve(filter, opts...)
+}
+
+// Update re-resolves a graph returned by Resolve after the filter changed
+// for the plugins with the given URIs.
+func Update(g *plugin.RegistrationGraph, filter plugin.DisableFilter, uris []string, opts ...plugin.GraphOpt) *plugin.GraphUpdate {
+	register.RLock()
+	defer register.RUnlock()
+	return register.r.Update(g, filter, uris, opts...)
+}
+
+// Validate returns an error if the registered plugins conflict or their
+// dependencies cannot be ordered.
+func Validate(filter plugin.DisableFilter, opts ...plugin.GraphOpt) error {
+	register.RLock()
+	defer register.RUnlock()
+	return register.r.Validate(filter, opts...)
+}
+
+// ValidateAll checks every registered plugin in a single pass, returning
+// every problem found. See plugin.Registry.ValidateAll.
+func ValidateAll(opts ...plugin.GraphOpt) error {
+	register.RLock()
+	defer register.RUnlock()
+	return register.r.ValidateAll(opts...)
+}
+
+// ConfigSchemas returns the JSON schema for the config of each registered
+// plugin, keyed by the plugin URI.
+func ConfigSchemas() map[string]*plugin.Schema {
+	register.RLock()
+	defer register.RUnlock()
+	return register.r.ConfigSchemas()
+}
+
+// SecuritySummary aggregates the security context of the registered plugins
+func SecuritySummary() plugin.SecuritySummary {
 	register.RLock()
 	defer register.RUnlock()
-	return register.r.Graph(filter)
+	return register.r.SecuritySummary()
 }
@@ -0,0 +1,131 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package registrygen generates build tag selected files which import the
+// plugin packages compiled into a binary. Plugins register themselves from
+// init, so each generated file (e.g. registry_linux.go, registry_minimal.go)
+// selects a plugin set at build time without filtering at runtime.
+package registrygen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/build/constraint"
+	"go/format"
+	"go/token"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Builtins describes a generated file importing a set of plugins
+type Builtins struct {
+	// Package is the name of the package of the generated file
+	Package string
+	// BuildTags is the build constraint expression selecting the file, such
+	// as "linux && !no_btrfs". An empty expression always builds.
+	BuildTags string
+	// Imports are the packages registering the plugins
+	Imports []string
+}
+
+// Validate checks the package name, build constraint and import paths
+func (b Builtins) Validate() error {
+	var errs []error
+	if !token.IsIdentifier(b.Package) {
+		errs = append(errs, fmt.Errorf("invalid package name %q", b.Package))
+	}
+	if b.BuildTags != "" {
+		if _, err := constraint.Parse("//go:build " + b.BuildTags); err != nil {
+			errs = append(errs, fmt.Errorf("invalid build tags %q: %w", b.BuildTags, err))
+		}
+	}
+	seen := map[string]bool{}
+	for _, imp := range b.Imports {
+		if imp == "" || strings.ContainsAny(imp, " \t\"`\\") {
+			errs = append(errs, fmt.Errorf("invalid import path %q", imp))
+		}
+		if seen[imp] {
+			errs = append(errs, fmt.Errorf("duplicate import path %q", imp))
+		}
+		seen[imp] = true
+	}
+	return errors.Join(errs...)
+}
+
+// Compose combines builtins into one file for the same package. Imports are
+// merged without duplicates and the build constraints must all be satisfied.
+func Compose(builtins ...Builtins) (Builtins, error) {
+	var (
+		composed Builtins
+		tags     []string
+		seen     = map[string]bool{}
+	)
+	for _, b := range builtins {
+		if composed.Package == "" {
+			composed.Package = b.Package
+		} else if b.Package != composed.Package {
+			return Builtins{}, fmt.Errorf("cannot compose package %q with %q", b.Package, composed.Package)
+		}
+		if b.BuildTags != "" {
+			tags = append(tags, "("+b.BuildTags+")")
+		}
+		for _, imp := range b.Imports {
+			if !seen[imp] {
+				seen[imp] = true
+				composed.Imports = append(composed.Imports, imp)
+			}
+		}
+	}
+	if len(tags) > 0 {
+		expr, err := constraint.Parse("//go:build " + strings.Join(tags, " && "))
+		if err != nil {
+			return Builtins{}, err
+		}
+		composed.BuildTags = expr.String()
+	}
+	return composed, composed.Validate()
+}
+
+// Generate writes the Go source of the builtins file after validating it
+func Generate(w io.Writer, b Builtins) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	imports := append([]string(nil), b.Imports...)
+	sort.Strings(imports)
+
+	var buf bytes.Buffer
+	if b.BuildTags != "" {
+		fmt.Fprintf(&buf, "//go:build %s\n\n", b.BuildTags)
+	}
+	buf.WriteString("// Code generated by registrygen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n", b.Package)
+	if len(imports) > 0 {
+		buf.WriteString("\nimport (\n")
+		for _, imp := range imports {
+			fmt.Fprintf(&buf, "\t_ %q\n", imp)
+		}
+		buf.WriteString(")\n")
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
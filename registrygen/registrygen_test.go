@@ -0,0 +1,72 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package registrygen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	b, err := Compose(Builtins{
+		Package:   "builtins",
+		BuildTags: "linux",
+		Imports:   []string{"example.com/plugins/snapshots/overlay", "example.com/plugins/content"},
+	}, Builtins{
+		Package:   "builtins",
+		BuildTags: "!no_btrfs",
+		Imports:   []string{"example.com/plugins/snapshots/btrfs", "example.com/plugins/content"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := Generate(&out, b); err != nil {
+		t.Fatal(err)
+	}
+	expected := `//go:build linux && !no_btrfs
+
+// Code generated by registrygen. DO NOT EDIT.
+
+package builtins
+
+import (
+	_ "example.com/plugins/content"
+	_ "example.com/plugins/snapshots/btrfs"
+	_ "example.com/plugins/snapshots/overlay"
+)
+`
+	if out.String() != expected {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, b := range []Builtins{
+		{Package: "not a package"},
+		{Package: "builtins", BuildTags: "linux &&"},
+		{Package: "builtins", Imports: []string{"example.com/a", "example.com/a"}},
+		{Package: "builtins", Imports: []string{`bad"path`}},
+	} {
+		if err := b.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", b)
+		}
+	}
+	if _, err := Compose(Builtins{Package: "a"}, Builtins{Package: "b"}); err == nil {
+		t.Error("expected composing different packages to fail")
+	}
+}
@@ -0,0 +1,84 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"time"
+)
+
+// RetryPolicy declares init errors as transient, such as a proxy plugin
+// whose backend socket is not listening yet. Loader.Load retries the
+// initialization of a plugin failing with a retryable error until the
+// attempts are exhausted, recording the last error as the plugin failure.
+type RetryPolicy struct {
+	// Attempts is the maximum number of times the plugin is initialized,
+	// including the first attempt
+	Attempts int
+	// Backoff is the delay before the first retry, doubled for every
+	// following retry
+	Backoff time.Duration
+	// MaxBackoff limits the delay between attempts, zero for no limit
+	MaxBackoff time.Duration
+	// Retryable returns true if the init error is transient. When nil,
+	// every error other than a permanent one is retried, such as missing
+	// configuration or properties, a missing dependency, an unexpected
+	// instance type or denied access. ErrSkipPlugin, including a platform
+	// mismatch, is never retried.
+	Retryable func(error) bool
+}
+
+// retryable returns true if another attempt should follow the failed one
+func (p *RetryPolicy) retryable(attempt int, err error) bool {
+	if p == nil || attempt >= p.Attempts || err == nil || IsSkipPlugin(err) {
+		return false
+	}
+	if p.Retryable == nil {
+		return !permanent(err)
+	}
+	return p.Retryable(err)
+}
+
+// permanent returns true if the init error cannot be resolved by another
+// attempt within the same load
+func permanent(err error) bool {
+	var depErr *DependencyError
+	if errors.As(err, &depErr) {
+		return true
+	}
+	for _, target := range []error{ErrMissingConfig, ErrMissingProperty, ErrInstanceType, ErrAccessDenied} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before the given retry, starting at 1
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry && d > 0; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
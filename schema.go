@@ -0,0 +1,213 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the JSON schema dialect used for generated config schemas
+const SchemaVersion = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON schema describing a plugin configuration.
+//
+// Schemas are generated from the Go type of a registration's Config. Field
+// names are taken from the `toml` struct tag, falling back to the `json` tag
+// and then the field name. The `description` and `default` struct tags may be
+// used to document a field.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	ID                   string             `json:"$id,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	PropertyNames        *Schema            `json:"propertyNames,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+}
+
+// ConfigSchemas returns the JSON schema for the Config of each registration,
+// keyed by the plugin URI. Registrations without a Config are omitted.
+func (registry Registry) ConfigSchemas() map[string]*Schema {
+	schemas := map[string]*Schema{}
+	for _, r := range registry {
+		if s := r.ConfigSchema(); s != nil {
+			schemas[r.URI()] = s
+		}
+	}
+	return schemas
+}
+
+// ConfigSchema returns the JSON schema for the registration's Config or nil
+// if the registration has no Config.
+func (r *Registration) ConfigSchema() *Schema {
+	if r.Config == nil {
+		return nil
+	}
+	s := typeSchema(reflect.TypeOf(r.Config), map[reflect.Type]bool{})
+	s.Schema = SchemaVersion
+	s.ID = r.URI()
+	return s
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// isTextType returns true if values of the type are written as strings in
+// the configuration, such as durations and types decoding themselves from text.
+func isTextType(t reflect.Type) bool {
+	return t == durationType || t.Implements(textUnmarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+func typeSchema(t reflect.Type, visiting map[reflect.Type]bool) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if isTextType(t) {
+		return &Schema{Type: "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string"}
+		}
+		return &Schema{Type: "array", Items: typeSchema(t.Elem(), visiting)}
+	case reflect.Map:
+		return &Schema{
+			Type:                 "object",
+			PropertyNames:        keySchema(t.Key()),
+			AdditionalProperties: typeSchema(t.Elem(), visiting),
+		}
+	case reflect.Struct:
+		if visiting[t] {
+			// Recursive types are left unconstrained
+			return &Schema{Type: "object"}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		structSchema(t, s, visiting)
+		return s
+	default:
+		// Interfaces and other kinds accept any value
+		return &Schema{}
+	}
+}
+
+func structSchema(t reflect.Type, s *Schema, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, ok := fieldName(f)
+		if !ok {
+			continue
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct && !isTextType(ft) {
+			// Embedded structs are flattened into the parent, recursive
+			// embeddings are only flattened once.
+			if !visiting[ft] {
+				visiting[ft] = true
+				structSchema(ft, s, visiting)
+				delete(visiting, ft)
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fs := typeSchema(f.Type, visiting)
+		fs.Description = f.Tag.Get("description")
+		if d, ok := f.Tag.Lookup("default"); ok {
+			fs.Default = parseDefault(fs.Type, d)
+		}
+		s.Properties[name] = fs
+	}
+}
+
+// keySchema returns the constraints on the names of map keys, keys are always
+// strings in the configuration but may be required to parse as integers.
+func keySchema(t reflect.Type) *Schema {
+	if isTextType(t) {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "string", Pattern: "^-?[0-9]+$"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "string", Pattern: "^[0-9]+$"}
+	}
+	return nil
+}
+
+// fieldName returns the configured name of a field, returning false if
+// the field is excluded from the configuration.
+func fieldName(f reflect.StructField) (string, bool) {
+	for _, key := range []string{"toml", "json"} {
+		tag, ok := f.Tag.Lookup(key)
+		if !ok {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			return "", false
+		}
+		return name, true
+	}
+	return "", true
+}
+
+func parseDefault(typ, v string) interface{} {
+	switch typ {
+	case "boolean":
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	case "integer":
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i
+		}
+	case "number":
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return v
+}
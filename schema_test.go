@@ -0,0 +1,144 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+type testEmbeddedConfig struct {
+	Shared string `toml:"shared"`
+}
+
+type testConfig struct {
+	testEmbeddedConfig
+	Root     string            `toml:"root" description:"Root directory"`
+	Verbose  bool              `toml:"verbose" default:"true"`
+	Workers  int               `toml:"workers" default:"4"`
+	Labels   map[string]string `toml:"labels"`
+	Mirrors  []string          `json:"mirrors"`
+	Ignored  string            `toml:"-"`
+	Nested   *testConfig       `toml:"nested"`
+	Timeout  time.Duration     `toml:"timeout" default:"10s"`
+	Address  net.IP            `toml:"address"`
+	Ports    map[int]string    `toml:"ports"`
+	internal string
+}
+
+type testRecursiveConfig struct {
+	*testRecursiveConfig
+	Name string `toml:"name"`
+}
+
+func TestConfigSchemas(t *testing.T) {
+	var register Registry
+	register = register.Register(&Registration{
+		Type: "service",
+		ID:   "configured",
+		Config: &testConfig{
+			testEmbeddedConfig: testEmbeddedConfig{Shared: "shared"},
+			Root:               "/var/lib/test",
+			Labels:             map[string]string{},
+			Mirrors:            []string{},
+			Ignored:            "ignored",
+			Ports:              map[int]string{},
+			internal:           "internal",
+		},
+	}).Register(&Registration{
+		Type: "service",
+		ID:   "unconfigured",
+	})
+
+	schemas := register.ConfigSchemas()
+	if len(schemas) != 1 {
+		t.Fatalf("expected 1 schema, got %d", len(schemas))
+	}
+	s, ok := schemas["service.configured"]
+	if !ok {
+		t.Fatal("missing schema for service.configured")
+	}
+	if s.Schema != SchemaVersion || s.ID != "service.configured" || s.Type != "object" {
+		t.Fatalf("unexpected schema header: %+v", s)
+	}
+
+	for name, expected := range map[string]string{
+		"shared":  "string",
+		"root":    "string",
+		"verbose": "boolean",
+		"workers": "integer",
+		"labels":  "object",
+		"mirrors": "array",
+		"nested":  "object",
+		"timeout": "string",
+		"address": "string",
+		"ports":   "object",
+	} {
+		p, ok := s.Properties[name]
+		if !ok {
+			t.Errorf("missing property %q", name)
+			continue
+		}
+		if p.Type != expected {
+			t.Errorf("unexpected type for %q: %q, expected %q", name, p.Type, expected)
+		}
+	}
+	for _, name := range []string{"Ignored", "internal", "testEmbeddedConfig"} {
+		if _, ok := s.Properties[name]; ok {
+			t.Errorf("unexpected property %q", name)
+		}
+	}
+	if d := s.Properties["root"].Description; d != "Root directory" {
+		t.Errorf("unexpected description %q", d)
+	}
+	if d := s.Properties["verbose"].Default; d != true {
+		t.Errorf("unexpected default %v", d)
+	}
+	if d := s.Properties["workers"].Default; d != int64(4) {
+		t.Errorf("unexpected default %v", d)
+	}
+	if d := s.Properties["timeout"].Default; d != "10s" {
+		t.Errorf("unexpected default %v", d)
+	}
+	if pn := s.Properties["ports"].PropertyNames; pn == nil || pn.Pattern == "" {
+		t.Errorf("expected integer map keys to be constrained, got %+v", pn)
+	}
+	if pn := s.Properties["labels"].PropertyNames; pn != nil {
+		t.Errorf("unexpected constraint on string map keys %+v", pn)
+	}
+	if s.Properties["nested"].Properties != nil {
+		t.Errorf("expected recursive config to be unconstrained")
+	}
+
+	if _, err := json.Marshal(schemas); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigSchemaRecursiveEmbed(t *testing.T) {
+	r := &Registration{
+		Type:   "service",
+		ID:     "recursive",
+		Config: &testRecursiveConfig{Name: "name"},
+	}
+	s := r.ConfigSchema()
+	if len(s.Properties) != 1 || s.Properties["name"] == nil {
+		t.Fatalf("unexpected properties %+v", s.Properties)
+	}
+}
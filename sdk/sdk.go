@@ -0,0 +1,144 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package sdk is the minimal surface third-party plugin authors need to
+// register a plugin. It is kept intentionally small and only grows in a
+// backwards compatible way, so plugins written against it continue to
+// build across containerd releases even as the plugin package changes.
+//
+// The sdk is part of the github.com/containerd/plugin module and is
+// versioned with it. It narrows the API plugins are written against, not
+// their dependencies: importing it still brings in the plugin and registry
+// packages, which perform the registration.
+package sdk
+
+import (
+	"context"
+
+	"github.com/containerd/plugin"
+	"github.com/containerd/plugin/registry"
+)
+
+// Type is the type of a plugin
+type Type = plugin.Type
+
+var (
+	// ErrSkipPlugin is returned from an InitFn to indicate the plugin should
+	// not be loaded, as opposed to failing to load.
+	ErrSkipPlugin = plugin.ErrSkipPlugin
+	// ErrPluginNotFound is returned when a requested plugin is not found
+	ErrPluginNotFound = plugin.ErrPluginNotFound
+	// ErrPluginMultipleInstances is returned when a single instance of a
+	// plugin type is requested but several are loaded.
+	ErrPluginMultipleInstances = plugin.ErrPluginMultipleInstances
+)
+
+// InitContext is the view of the plugin initialization context available
+// to plugins registered through the sdk.
+type InitContext interface {
+	// Context returns the context for initialization
+	Context() context.Context
+	// Properties returns the properties provided by the host
+	Properties() map[string]string
+	// Config returns the decoded configuration of the plugin
+	Config() interface{}
+	// Export sets a value exported by the plugin
+	Export(key, value string)
+	// AddCapability adds a feature switch for the plugin
+	AddCapability(capability string)
+	// GetSingle returns the only instance of the given type
+	GetSingle(t Type) (interface{}, error)
+	// GetByID returns the instance of the given type and ID
+	GetByID(t Type, id string) (interface{}, error)
+	// GetByType returns all instances of the given type keyed by ID
+	GetByType(t Type) (map[string]interface{}, error)
+}
+
+// InitFn initializes a plugin, returning its instance
+type InitFn func(InitContext) (interface{}, error)
+
+// Registration contains the information for registering a plugin
+type Registration struct {
+	// Type of the plugin
+	Type Type
+	// ID of the plugin
+	ID string
+	// Config is the default configuration of the plugin
+	Config interface{}
+	// Requires is a list of plugin types or plugin URIs required to be
+	// initialized first
+	Requires []Type
+	// InitFn is called to initialize the plugin
+	InitFn InitFn
+}
+
+// Register adds the plugin to the global plugin registry
+func Register(r *Registration) {
+	registry.Register(r.Registration())
+}
+
+// Registration returns the plugin registration for the sdk registration
+func (r *Registration) Registration() *plugin.Registration {
+	initFn := r.InitFn
+	return &plugin.Registration{
+		Type:     r.Type,
+		ID:       r.ID,
+		Config:   r.Config,
+		Requires: r.Requires,
+		InitFn: func(ic *plugin.InitContext) (interface{}, error) {
+			return initFn(initContext{ic})
+		},
+	}
+}
+
+type initContext struct {
+	ic *plugin.InitContext
+}
+
+func (i initContext) Context() context.Context {
+	return i.ic.Context
+}
+
+func (i initContext) Properties() map[string]string {
+	return i.ic.Properties
+}
+
+func (i initContext) Config() interface{} {
+	return i.ic.Config
+}
+
+func (i initContext) Export(key, value string) {
+	if i.ic.Meta.Exports == nil {
+		i.ic.Meta.Exports = map[string]string{}
+	}
+	i.ic.Meta.Exports[key] = value
+}
+
+func (i initContext) AddCapability(capability string) {
+	i.ic.Meta.Capabilities = append(i.ic.Meta.Capabilities, capability)
+}
+
+func (i initContext) GetSingle(t Type) (interface{}, error) {
+	return i.ic.GetSingle(t)
+}
+
+func (i initContext) GetByID(t Type, id string) (interface{}, error) {
+	return i.ic.GetByID(t, id)
+}
+
+func (i initContext) GetByType(t Type) (map[string]interface{}, error) {
+	return i.ic.GetByType(t)
+}
@@ -0,0 +1,54 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+// SecurityContext declares the privileged operations a plugin may perform
+type SecurityContext struct {
+	// ExecsBinaries is set when the plugin executes external binaries
+	ExecsBinaries bool
+	// Listens is set when the plugin opens network listeners
+	Listens bool
+	// HostDevices is set when the plugin accesses host devices
+	HostDevices bool
+}
+
+// SecuritySummary lists the URIs of the plugins performing each kind of
+// privileged operation, in registration order.
+type SecuritySummary struct {
+	ExecsBinaries []string
+	Listens       []string
+	HostDevices   []string
+}
+
+// SecuritySummary aggregates the security context of the registrations to
+// review the attack surface of a composed daemon. Filter the registry with
+// GraphRefs first to only include enabled plugins.
+func (registry Registry) SecuritySummary() SecuritySummary {
+	var s SecuritySummary
+	for _, r := range registry {
+		if r.Security.ExecsBinaries {
+			s.ExecsBinaries = append(s.ExecsBinaries, r.URI())
+		}
+		if r.Security.Listens {
+			s.Listens = append(s.Listens, r.URI())
+		}
+		if r.Security.HostDevices {
+			s.HostDevices = append(s.HostDevices, r.URI())
+		}
+	}
+	return s
+}
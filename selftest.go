@@ -0,0 +1,130 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SelfTester is implemented by plugin instances which can check that they
+// are functional, such as a snapshotter creating and removing a snapshot.
+type SelfTester interface {
+	SelfTest(context.Context) error
+}
+
+// SelfTestResult is the outcome of the self-test of a single plugin
+type SelfTestResult struct {
+	// Plugin is the URI of the tested plugin
+	Plugin string
+	// Err is the self-test error, nil when the self-test passed
+	Err error
+	// Duration is how long the self-test ran
+	Duration time.Duration
+}
+
+type selfTestOptions struct {
+	parallelism int
+	timeout     time.Duration
+}
+
+// SelfTestOpt is an option for Set.SelfTest
+type SelfTestOpt func(*selfTestOptions)
+
+// WithSelfTestParallelism runs up to n self-tests concurrently
+func WithSelfTestParallelism(n int) SelfTestOpt {
+	return func(o *selfTestOptions) {
+		o.parallelism = n
+	}
+}
+
+// WithSelfTestTimeout limits the time each self-test may run. A self-test
+// exceeding the timeout fails with context.DeadlineExceeded.
+func WithSelfTestTimeout(d time.Duration) SelfTestOpt {
+	return func(o *selfTestOptions) {
+		o.timeout = d
+	}
+}
+
+// SelfTest runs the self-test of every active plugin implementing SelfTester
+// and returns the results in initialization order. Failing self-tests do not
+// change the plugin state, the report is intended for preflight diagnostics.
+func (ps *Set) SelfTest(ctx context.Context, opts ...SelfTestOpt) []SelfTestResult {
+	o := selfTestOptions{parallelism: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.parallelism < 1 {
+		o.parallelism = 1
+	}
+
+	var (
+		testers []SelfTester
+		results []SelfTestResult
+	)
+	for _, p := range ps.ordered {
+		if p.State() != StateActive {
+			continue
+		}
+		instance, _ := p.Instance()
+		if st, ok := instance.(SelfTester); ok {
+			testers = append(testers, st)
+			results = append(results, SelfTestResult{Plugin: p.Registration.URI()})
+		}
+	}
+
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, o.parallelism)
+	)
+	for i := range testers {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			start := time.Now()
+			results[i].Err = runWithTimeout(ctx, o.timeout, testers[i].SelfTest)
+			results[i].Duration = time.Since(start)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// runWithTimeout returns once fn completes or the timeout expires, a
+// function ignoring its context is abandoned rather than waited on.
+func runWithTimeout(ctx context.Context, timeout time.Duration, fn func(context.Context) error) error {
+	if timeout <= 0 {
+		return fn(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fn(ctx)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,77 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+// Dependency is an edge in the dependency graph of a plugin set
+type Dependency struct {
+	// Plugin is the URI of the dependent plugin
+	Plugin string
+	// Requires is the URI of the plugin it depends on
+	Requires string
+}
+
+// Graph returns the dependencies between the plugins currently in the set.
+// Edges are ordered by the initialization order of the dependent plugin and
+// then of the dependency. The graph is kept up to date as plugins are added
+// and removed.
+func (ps *Set) Graph() []Dependency {
+	var deps []Dependency
+	for _, p := range ps.ordered {
+		edges := ps.requires[p]
+		if len(edges) == 0 {
+			continue
+		}
+		for _, d := range ps.ordered {
+			if _, ok := edges[d]; ok {
+				deps = append(deps, Dependency{Plugin: p.Registration.URI(), Requires: d.Registration.URI()})
+			}
+		}
+	}
+	return deps
+}
+
+// addEdges adds the dependencies of a newly added plugin along with edges
+// from plugins already in the set which require it.
+func (ps *Set) addEdges(p *Plugin) {
+	if ps.requires == nil {
+		ps.requires = map[*Plugin]map[*Plugin]struct{}{}
+	}
+	for _, o := range ps.ordered {
+		if requires(&p.Registration, &o.Registration) {
+			ps.addEdge(p, o)
+		}
+		if requires(&o.Registration, &p.Registration) {
+			ps.addEdge(o, p)
+		}
+	}
+}
+
+func (ps *Set) addEdge(p, dep *Plugin) {
+	edges, ok := ps.requires[p]
+	if !ok {
+		edges = map[*Plugin]struct{}{}
+		ps.requires[p] = edges
+	}
+	edges[dep] = struct{}{}
+}
+
+func (ps *Set) removeEdges(p *Plugin) {
+	delete(ps.requires, p)
+	for _, edges := range ps.requires {
+		delete(edges, p)
+	}
+}
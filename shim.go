@@ -0,0 +1,119 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import "fmt"
+
+// Shim adapts plugins of one type to satisfy lookups for another type. Shims
+// allow consumers of a renamed type to keep working while plugins of the old
+// type are still loaded, or the other way around.
+type Shim struct {
+	// From is the type of the loaded plugins being adapted
+	From Type
+	// To is the type the adapted plugins are returned for
+	To Type
+	// Convert adapts an instance of From to an instance of To
+	Convert func(interface{}) (interface{}, error)
+}
+
+// WithShims adds shims used by lookups on the set. Plugins loaded with the
+// requested type always take precedence over adapted plugins with the same ID.
+func WithShims(shims ...Shim) SetOpt {
+	return func(ps *Set) {
+		if ps.shims == nil {
+			ps.shims = map[Type][]Shim{}
+		}
+		for _, s := range shims {
+			ps.shims[s.To] = append(ps.shims[s.To], s)
+		}
+	}
+}
+
+// adapt returns a plugin presenting p as the shim's target type
+func (s Shim) adapt(p *Plugin) *Plugin {
+	instance, err := p.Instance()
+	if err == nil {
+		if instance, err = s.Convert(instance); err != nil {
+			err = fmt.Errorf("shim %s to %s failed for %s: %w", s.From, s.To, p.Registration.URI(), err)
+		}
+	}
+	r := p.Registration
+	r.Type = s.To
+	return &Plugin{
+		Registration: r,
+		Config:       p.Config,
+		Meta:         p.Meta,
+		instance:     instance,
+		err:          err,
+	}
+}
+
+// byType returns the plugins for the given type keyed by ID, including
+// plugins adapted from other types by shims and plugins delegated to the
+// parent set.
+func (ps *Set) byType(t Type) map[string]*Plugin {
+	if ps.delegated[t] {
+		return ps.parent.byType(t)
+	}
+	shims := ps.shims[t]
+	if len(shims) == 0 {
+		return ps.byTypeAndID[t]
+	}
+	plugins := map[string]*Plugin{}
+	for _, s := range shims {
+		for id, p := range ps.byTypeAndID[s.From] {
+			if _, ok := plugins[id]; !ok {
+				plugins[id] = s.adapt(p)
+			}
+		}
+	}
+	for id, p := range ps.byTypeAndID[t] {
+		plugins[id] = p
+	}
+	return plugins
+}
+
+// orderedByType returns the plugins returned by byType in the order the
+// plugins they originate from were added to the set.
+func (ps *Set) orderedByType(t Type) []*Plugin {
+	if ps.delegated[t] {
+		return ps.parent.orderedByType(t)
+	}
+	plugins := ps.byType(t)
+	ordered := make([]*Plugin, 0, len(plugins))
+	for _, p := range ps.ordered {
+		id := p.Registration.ID
+		if q, ok := plugins[id]; ok && ps.source(t, id) == p {
+			ordered = append(ordered, q)
+		}
+	}
+	return ordered
+}
+
+// source returns the loaded plugin which byType returns for the ID, either
+// directly or adapted by the first matching shim.
+func (ps *Set) source(t Type, id string) *Plugin {
+	if p, ok := ps.byTypeAndID[t][id]; ok {
+		return p
+	}
+	for _, s := range ps.shims[t] {
+		if p, ok := ps.byTypeAndID[s.From][id]; ok {
+			return p
+		}
+	}
+	return nil
+}
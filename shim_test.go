@@ -0,0 +1,66 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestShims(t *testing.T) {
+	errConvert := errors.New("cannot convert")
+	ps := NewPluginSet(WithShims(Shim{
+		From: "runtime.v1",
+		To:   "runtime.v2",
+		Convert: func(i interface{}) (interface{}, error) {
+			if i == "broken" {
+				return nil, errConvert
+			}
+			return "v2:" + i.(string), nil
+		},
+	}))
+	for _, p := range []*Plugin{
+		testPlugin("runtime.v1", "linux", "linux", nil),
+		testPlugin("runtime.v1", "task", "shadowed", nil),
+		testPlugin("runtime.v1", "broken", "broken", nil),
+		testPlugin("runtime.v2", "task", "task", nil),
+	} {
+		if err := ps.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ic := InitContext{plugins: ps}
+
+	if i, err := ic.GetByID("runtime.v2", "linux"); err != nil || i != "v2:linux" {
+		t.Fatalf("unexpected shimmed instance %v: %v", i, err)
+	}
+	if i, err := ic.GetByID("runtime.v2", "task"); err != nil || i != "task" {
+		t.Fatalf("expected loaded plugin to take precedence, got %v: %v", i, err)
+	}
+	if _, err := ic.GetByID("runtime.v2", "broken"); !errors.Is(err, errConvert) {
+		t.Fatalf("expected conversion error, got %v", err)
+	}
+	if i, err := ic.GetByID("runtime.v1", "linux"); err != nil || i != "linux" {
+		t.Fatalf("unexpected original instance %v: %v", i, err)
+	}
+	if _, err := ic.GetByType("runtime.v2"); !errors.Is(err, errConvert) {
+		t.Fatalf("expected conversion error from GetByType, got %v", err)
+	}
+	if p := ps.Get("runtime.v2", "linux"); p == nil || p.Registration.Type != "runtime.v2" || p.Registration.ID != "linux" {
+		t.Fatalf("unexpected adapted plugin %+v", p)
+	}
+}
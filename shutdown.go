@@ -0,0 +1,165 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+type shutdownOptions struct {
+	concurrency int
+}
+
+// ShutdownOpt is an option for Set.Shutdown
+type ShutdownOpt func(*shutdownOptions)
+
+// WithShutdownConcurrency stops up to n plugins concurrently
+func WithShutdownConcurrency(n int) ShutdownOpt {
+	return func(o *shutdownOptions) {
+		o.concurrency = n
+	}
+}
+
+type shutdownResult struct {
+	p       *Plugin
+	stopped bool
+	err     error
+}
+
+// Shutdown stops every plugin in the set, closing instances implementing
+// io.Closer and running their finalizers. A plugin is only stopped once all
+// plugins depending on it have been stopped, independent plugins are stopped
+// concurrently up to the configured limit in order of ShutdownPriority and
+// then reverse initialization order. Running plugins transition to the
+// stopped state and an event is emitted for each. Errors from every failed
+// close or finalizer are joined.
+func (ps *Set) Shutdown(ctx context.Context, opts ...ShutdownOpt) error {
+	o := shutdownOptions{concurrency: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.concurrency < 1 {
+		o.concurrency = 1
+	}
+
+	ps.lifecycle.Lock()
+	index := make(map[*Plugin]int, len(ps.ordered))
+	for i, p := range ps.ordered {
+		index[p] = i
+	}
+	// dependents counts the plugins which must stop before each plugin
+	dependents := map[*Plugin]int{}
+	dependencies := make(map[*Plugin][]*Plugin, len(ps.requires))
+	for p, deps := range ps.requires {
+		for dep := range deps {
+			dependents[dep]++
+			dependencies[p] = append(dependencies[p], dep)
+		}
+	}
+	ps.lifecycle.Unlock()
+
+	var (
+		ready     []*Plugin
+		remaining = make(map[*Plugin]bool, len(index))
+	)
+	for p := range index {
+		remaining[p] = true
+		if dependents[p] == 0 {
+			ready = append(ready, p)
+		}
+	}
+
+	var (
+		errs    []error
+		running int
+		results = make(chan shutdownResult)
+	)
+	collect := func() *Plugin {
+		r := <-results
+		running--
+		if r.err != nil {
+			errs = append(errs, r.err)
+		}
+		if r.stopped {
+			ps.emit([]Event{{Plugin: r.p.Registration.URI(), State: StateStopped}})
+		}
+		return r.p
+	}
+	for len(remaining) > 0 {
+		if len(ready) == 0 && running == 0 {
+			// Dependency cycles between wildcard requirements are broken
+			// by reverse initialization order.
+			for p := range remaining {
+				if len(ready) == 0 || index[p] > index[ready[0]] {
+					ready = []*Plugin{p}
+				}
+			}
+		}
+		sort.Slice(ready, func(i, j int) bool {
+			pi, pj := ready[i].Registration.ShutdownPriority, ready[j].Registration.ShutdownPriority
+			if pi != pj {
+				return pi > pj
+			}
+			return index[ready[i]] > index[ready[j]]
+		})
+		for running < o.concurrency && len(ready) > 0 {
+			p := ready[0]
+			ready = ready[1:]
+			delete(remaining, p)
+			running++
+			go func() {
+				results <- ps.stop(ctx, p)
+			}()
+		}
+
+		for _, dep := range dependencies[collect()] {
+			dependents[dep]--
+			if dependents[dep] == 0 && remaining[dep] {
+				ready = append(ready, dep)
+			}
+		}
+	}
+	for running > 0 {
+		collect()
+	}
+	ps.publish()
+	return errors.Join(errs...)
+}
+
+// stop closes the instance of a running plugin and runs its finalizers
+func (ps *Set) stop(ctx context.Context, p *Plugin) shutdownResult {
+	r := shutdownResult{p: p}
+	var errs []error
+	switch p.State() {
+	case StateActive, StateDegraded:
+		if c, ok := p.closer(); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("failed to close %s: %w", p.Registration.URI(), err))
+			}
+		}
+		p.transition(StateStopped, nil)
+		r.stopped = true
+	}
+	if err := ps.GC(ctx, p.Registration.URI()); err != nil {
+		errs = append(errs, err)
+	}
+	r.err = errors.Join(errs...)
+	return r
+}
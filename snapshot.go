@@ -0,0 +1,109 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	imagespec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// PluginStatus is the state of a plugin captured in a Snapshot
+type PluginStatus struct {
+	Registration *Registration
+	Meta         Meta
+	Instance     interface{}
+	State        State
+	Err          error
+}
+
+// Snapshot is an immutable view of the plugins in a set
+type Snapshot struct {
+	// Generation is incremented for every published snapshot
+	Generation uint64
+	// Plugins holds the status of each plugin in initialization order
+	Plugins []PluginStatus
+}
+
+// Snapshot returns the latest published view of the set. A new snapshot is
+// published after every change to the set, or once at the end of a Batch,
+// so readers such as introspection never observe a partially applied
+// reload. The returned snapshot must not be modified.
+func (ps *Set) Snapshot() *Snapshot {
+	if s := ps.snapshot.Load(); s != nil {
+		return s
+	}
+	return &Snapshot{}
+}
+
+// Batch applies several changes to the set, such as removing and adding
+// plugins during a reload, publishing a single snapshot once fn returns.
+func (ps *Set) Batch(fn func() error) error {
+	ps.snapMu.Lock()
+	ps.batching++
+	ps.snapMu.Unlock()
+
+	err := fn()
+
+	ps.snapMu.Lock()
+	ps.batching--
+	ps.snapMu.Unlock()
+	ps.publish()
+	return err
+}
+
+// publish stores a new snapshot of the set unless a batch is in progress
+func (ps *Set) publish() {
+	ps.snapMu.Lock()
+	defer ps.snapMu.Unlock()
+	if ps.batching > 0 {
+		return
+	}
+	s := &Snapshot{
+		Plugins: make([]PluginStatus, len(ps.ordered)),
+	}
+	if prev := ps.snapshot.Load(); prev != nil {
+		s.Generation = prev.Generation
+	}
+	s.Generation++
+	for i, p := range ps.ordered {
+		p.mu.Lock()
+		s.Plugins[i] = PluginStatus{
+			Registration: &p.Registration,
+			Meta:         p.Meta.clone(),
+			Instance:     p.instance,
+		}
+		p.mu.Unlock()
+		s.Plugins[i].State = p.State()
+		s.Plugins[i].Err = p.StateErr()
+	}
+	ps.snapshot.Store(s)
+}
+
+// clone returns a deep copy of the metadata so a snapshot does not share
+// the exports and capabilities the plugin may still modify
+func (m Meta) clone() Meta {
+	c := Meta{
+		Platforms:    append([]imagespec.Platform(nil), m.Platforms...),
+		Capabilities: append([]string(nil), m.Capabilities...),
+	}
+	if m.Exports != nil {
+		c.Exports = make(map[string]string, len(m.Exports))
+		for k, v := range m.Exports {
+			c.Exports[k] = v
+		}
+	}
+	return c
+}
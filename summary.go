@@ -0,0 +1,110 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TypeSummary counts the plugins of a type by state
+type TypeSummary struct {
+	Type     Type
+	Active   int
+	Skipped  int
+	Failed   int
+	Degraded int
+	Stopped  int
+}
+
+// Total returns the number of plugins of the type
+func (s TypeSummary) Total() int {
+	return s.Active + s.Skipped + s.Failed + s.Degraded + s.Stopped
+}
+
+// Summary is the composition of a plugin set for logging and metrics
+type Summary struct {
+	// Types holds the counts for each type, sorted by type
+	Types []TypeSummary
+}
+
+// Total returns the number of plugins in the set
+func (s Summary) Total() int {
+	var total int
+	for _, t := range s.Types {
+		total += t.Total()
+	}
+	return total
+}
+
+// String formats the summary as a single line, such as
+// "content: 1 active; snapshotter: 3 active, 1 skipped"
+func (s Summary) String() string {
+	parts := make([]string, 0, len(s.Types))
+	for _, t := range s.Types {
+		var counts []string
+		for _, c := range []struct {
+			state State
+			n     int
+		}{
+			{StateActive, t.Active},
+			{StateSkipped, t.Skipped},
+			{StateFailed, t.Failed},
+			{StateDegraded, t.Degraded},
+			{StateStopped, t.Stopped},
+		} {
+			if c.n > 0 {
+				counts = append(counts, fmt.Sprintf("%d %s", c.n, c.state))
+			}
+		}
+		parts = append(parts, fmt.Sprintf("%s: %s", t.Type, strings.Join(counts, ", ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Summary counts the plugins in the set by type and state
+func (ps *Set) Summary() Summary {
+	byType := map[Type]*TypeSummary{}
+	for _, p := range ps.ordered {
+		t := byType[p.Registration.Type]
+		if t == nil {
+			t = &TypeSummary{Type: p.Registration.Type}
+			byType[p.Registration.Type] = t
+		}
+		switch p.State() {
+		case StateActive:
+			t.Active++
+		case StateSkipped:
+			t.Skipped++
+		case StateFailed:
+			t.Failed++
+		case StateDegraded:
+			t.Degraded++
+		case StateStopped:
+			t.Stopped++
+		}
+	}
+	s := Summary{Types: make([]TypeSummary, 0, len(byType))}
+	for _, t := range byType {
+		s.Types = append(s.Types, *t)
+	}
+	sort.Slice(s.Types, func(i, j int) bool {
+		return s.Types[i].Type < s.Types[j].Type
+	})
+	return s
+}
@@ -0,0 +1,134 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"sync"
+)
+
+// SyncRegistry is a registry which is safe to modify from multiple
+// goroutines, such as when plugins are discovered at runtime. Invalid
+// registrations are returned as errors rather than panicking. The zero
+// value is an empty registry ready to use.
+type SyncRegistry struct {
+	mu         sync.RWMutex
+	r          Registry
+	validators []Validator
+	sealed     bool
+}
+
+// Seal marks the registry as final, later calls to Register, Replace and
+// Deregister fail with ErrRegistrySealed. Snapshots of a sealed registry
+// never change, so graphs computed from them may be cached.
+func (s *SyncRegistry) Seal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sealed = true
+}
+
+// Sealed returns true if the registry was sealed
+func (s *SyncRegistry) Sealed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sealed
+}
+
+// AddValidator adds a validator run against every later registration,
+// registrations rejected by a validator are not added.
+func (s *SyncRegistry) AddValidator(v Validator) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.validators = append(s.validators, v)
+}
+
+// Register adds the registration to the registry
+func (s *SyncRegistry) Register(r *Registration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sealed {
+		return fmt.Errorf("register %s: %w", r.URI(), ErrRegistrySealed)
+	}
+	if err := check(r, s.validators); err != nil {
+		return err
+	}
+	return update(&s.r, func(registry Registry) Registry {
+		return registry.Register(r)
+	})
+}
+
+// RegisterBundle adds the registrations atomically, none are added if any
+// registration is invalid or rejected by a validator.
+func (s *SyncRegistry) RegisterBundle(name string, regs ...*Registration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sealed {
+		return fmt.Errorf("register bundle %s: %w", name, ErrRegistrySealed)
+	}
+	for _, r := range regs {
+		if err := check(r, s.validators); err != nil {
+			return err
+		}
+	}
+	return update(&s.r, func(registry Registry) Registry {
+		return registry.RegisterBundle(name, regs...)
+	})
+}
+
+// Replace substitutes the registration with the same URI
+func (s *SyncRegistry) Replace(r *Registration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sealed {
+		return fmt.Errorf("replace %s: %w", r.URI(), ErrRegistrySealed)
+	}
+	if err := check(r, s.validators); err != nil {
+		return err
+	}
+	return update(&s.r, func(registry Registry) Registry {
+		return registry.Replace(r)
+	})
+}
+
+// Deregister removes the registration with the given type and ID, returning
+// false if no registration matched or the registry is sealed.
+func (s *SyncRegistry) Deregister(t Type, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sealed {
+		return false
+	}
+	n := len(s.r)
+	s.r = s.r.Deregister(t, id)
+	return len(s.r) != n
+}
+
+// Snapshot returns an immutable view of the registry for computing the
+// graph, later changes to the SyncRegistry are not reflected in it.
+func (s *SyncRegistry) Snapshot() Registry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.r[:len(s.r):len(s.r)]
+}
+
+// update replaces the registry with the result of fn, returning the error
+// fn panicked with instead.
+func update(registry *Registry, fn func(Registry) Registry) (err error) {
+	defer recoverGraph(&err)
+	*registry = fn(*registry)
+	return nil
+}
@@ -0,0 +1,112 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrRemovedType is used when a registration refers to a plugin type which
+// has been removed or renamed.
+var ErrRemovedType = errors.New("plugin: removed type")
+
+// Tombstone records a plugin type which was removed or renamed
+type Tombstone struct {
+	// Type is the removed plugin type
+	Type Type
+	// Replacement is the type replacing the removed type, if any
+	Replacement Type
+	// Note describes the removal, such as the version it was removed in
+	Note string
+}
+
+// RemovedTypeError is returned when a registration refers to a type with a
+// tombstone, naming the replacement type to refer to instead.
+type RemovedTypeError struct {
+	// Plugin is the URI of the registration referring to the removed type
+	Plugin string
+	// Field is the registration field referring to the removed type
+	Field string
+	Tombstone
+}
+
+func (e *RemovedTypeError) Error() string {
+	msg := fmt.Sprintf("%s: %s type %s was removed", e.Plugin, e.Field, e.Type)
+	if e.Replacement != "" {
+		msg += fmt.Sprintf(", use %s instead", e.Replacement)
+	}
+	if e.Note != "" {
+		msg += " (" + e.Note + ")"
+	}
+	return msg
+}
+
+// Unwrap returns ErrRemovedType
+func (e *RemovedTypeError) Unwrap() error {
+	return ErrRemovedType
+}
+
+// CheckTombstones returns a RemovedTypeError for every dependency of a
+// registration on a type with a tombstone, joined into a single error.
+// Tombstones are matched in the same way as Requires entries match
+// registrations: a tombstone may name a type, a plugin URI or a glob
+// pattern, and dependencies on a plugin URI of a removed type or with a
+// version constraint are matched by their type.
+func (registry Registry) CheckTombstones(tombstones []Tombstone) error {
+	var errs []error
+	for _, r := range registry {
+		for _, field := range []struct {
+			name  string
+			types []Type
+		}{
+			{"requires", r.Requires},
+			{"optional", r.Optional},
+			{"barrier", r.Barrier},
+			{"before", r.Before},
+		} {
+			for _, t := range field.types {
+				if ts, ok := tombstoned(t, tombstones); ok {
+					errs = append(errs, &RemovedTypeError{Plugin: r.URI(), Field: field.name, Tombstone: ts})
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// tombstoned returns the first tombstone matching the dependency entry,
+// which refers either to a type or to the URI of a plugin
+func tombstoned(t Type, tombstones []Tombstone) (Tombstone, bool) {
+	t, _, _ = t.constraint()
+	if t == "*" || t.isPattern() {
+		return Tombstone{}, false
+	}
+	candidates := []*Registration{{Type: t}}
+	if i := strings.LastIndexByte(string(t), '.'); i > 0 {
+		candidates = append(candidates, &Registration{Type: t[:i], ID: string(t[i+1:])})
+	}
+	for _, ts := range tombstones {
+		for _, r := range candidates {
+			if ts.Type.Matches(r) {
+				return ts, true
+			}
+		}
+	}
+	return Tombstone{}, false
+}
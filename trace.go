@@ -0,0 +1,205 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Init outcomes recorded in a trace
+const (
+	OutcomeInitialized = "initialized"
+	OutcomeSkipped     = "skipped"
+	OutcomeFailed      = "failed"
+)
+
+// TraceEntry is the record of a single plugin initialization
+type TraceEntry struct {
+	Plugin     string        `json:"plugin"`
+	ConfigHash string        `json:"config_hash,omitempty"`
+	Duration   time.Duration `json:"duration"`
+	Outcome    string        `json:"outcome"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// InitTrace records the plugin initializations of a startup in order. A
+// trace saved on one node can be replayed against the registry on another
+// to find why their startups diverge.
+type InitTrace struct {
+	mu      sync.Mutex
+	Entries []TraceEntry `json:"entries"`
+}
+
+// WithInitTrace records the initialization of the plugin to the trace
+func WithInitTrace(t *InitTrace) InitContextOpt {
+	return func(ic *InitContext) {
+		ic.trace = t
+	}
+}
+
+func (t *InitTrace) record(r *Registration, config interface{}, d time.Duration, err error) {
+	e := TraceEntry{
+		Plugin:     r.URI(),
+		ConfigHash: configHash(config),
+		Duration:   d,
+		Outcome:    OutcomeInitialized,
+	}
+	if err != nil {
+		e.Outcome = OutcomeFailed
+		if IsSkipPlugin(err) {
+			e.Outcome = OutcomeSkipped
+		}
+		e.Error = err.Error()
+	}
+	t.mu.Lock()
+	t.Entries = append(t.Entries, e)
+	t.mu.Unlock()
+}
+
+// configHash returns a digest of the configuration, configurations which
+// cannot be encoded are hashed from their printed value.
+func configHash(config interface{}) string {
+	if config == nil {
+		return ""
+	}
+	b, err := json.Marshal(config)
+	if err != nil {
+		b = []byte(fmt.Sprintf("%#v", config))
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Save writes the trace to a file
+func (t *InitTrace) Save(path string) error {
+	t.mu.Lock()
+	b, err := json.MarshalIndent(t, "", "  ")
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+// LoadInitTrace reads a trace written by Save
+func LoadInitTrace(path string) (*InitTrace, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t InitTrace
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, fmt.Errorf("invalid init trace %s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// Divergence is a difference between a recorded startup and the current one
+type Divergence struct {
+	// Plugin is the URI of the diverging plugin
+	Plugin string
+	// Reason describes the divergence
+	Reason string
+}
+
+func (d Divergence) String() string {
+	return d.Plugin + ": " + d.Reason
+}
+
+// Replay compares the recorded initialization order against the order the
+// current registry would initialize plugins in, as returned by GraphRefs.
+func (t *InitTrace) Replay(ordered []*Registration) []Divergence {
+	current := &InitTrace{}
+	for _, r := range ordered {
+		current.Entries = append(current.Entries, TraceEntry{Plugin: r.URI()})
+	}
+	return t.compare(current, false)
+}
+
+// Compare returns the differences between the trace and another trace, such
+// as one recorded on a different node. Plugins initialized in a different
+// order, with a different configuration or with a different outcome are
+// reported.
+func (t *InitTrace) Compare(other *InitTrace) []Divergence {
+	return t.compare(other, true)
+}
+
+func (t *InitTrace) compare(other *InitTrace, outcomes bool) []Divergence {
+	var (
+		divergences []Divergence
+		recorded    = map[string]int{}
+		current     = map[string]int{}
+	)
+	for i, e := range t.Entries {
+		recorded[e.Plugin] = i
+	}
+	for i, e := range other.Entries {
+		current[e.Plugin] = i
+	}
+	for _, e := range t.Entries {
+		if _, ok := current[e.Plugin]; !ok {
+			divergences = append(divergences, Divergence{Plugin: e.Plugin, Reason: "missing"})
+		}
+	}
+
+	// Relative order is compared on the plugins present in both runs so a
+	// single missing plugin does not shift every later position.
+	var common []TraceEntry
+	for _, e := range other.Entries {
+		if _, ok := recorded[e.Plugin]; !ok {
+			divergences = append(divergences, Divergence{Plugin: e.Plugin, Reason: "not in trace"})
+			continue
+		}
+		common = append(common, e)
+	}
+	last := -1
+	for _, e := range common {
+		i := recorded[e.Plugin]
+		if i < last {
+			divergences = append(divergences, Divergence{Plugin: e.Plugin, Reason: "initialized in a different order"})
+		} else {
+			last = i
+		}
+		if !outcomes {
+			continue
+		}
+		r := t.Entries[i]
+		if r.ConfigHash != e.ConfigHash {
+			divergences = append(divergences, Divergence{Plugin: e.Plugin, Reason: "config differs"})
+		}
+		if r.Outcome != e.Outcome {
+			divergences = append(divergences, Divergence{
+				Plugin: e.Plugin,
+				Reason: fmt.Sprintf("outcome %s, recorded %s", describeOutcome(e), describeOutcome(r)),
+			})
+		}
+	}
+	return divergences
+}
+
+func describeOutcome(e TraceEntry) string {
+	if e.Error != "" {
+		return fmt.Sprintf("%s (%s)", e.Outcome, e.Error)
+	}
+	return e.Outcome
+}
@@ -0,0 +1,86 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+type traceConfig struct {
+	Root string
+}
+
+func traceRun(t *testing.T, root string, snapshotErr error) *InitTrace {
+	t.Helper()
+	var registry Registry
+	registry = registry.Register(&Registration{
+		Type:   "content",
+		ID:     "local",
+		Config: &traceConfig{Root: root},
+		InitFn: func(*InitContext) (interface{}, error) { return nil, nil },
+	}).Register(&Registration{
+		Type:     "snapshotter",
+		ID:       "overlayfs",
+		Requires: []Type{"content"},
+		InitFn:   func(*InitContext) (interface{}, error) { return nil, snapshotErr },
+	})
+
+	trace := &InitTrace{}
+	set := NewPluginSet()
+	for _, r := range registry.GraphRefs(mockPluginFilter) {
+		ic := NewContext(context.Background(), set, nil, WithInitTrace(trace))
+		ic.Config = r.Config
+		set.Add(r.Init(ic))
+	}
+	return trace
+}
+
+func TestInitTrace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.json")
+	if err := traceRun(t, "/var/lib", nil).Save(path); err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := LoadInitTrace(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded.Entries) != 2 || recorded.Entries[0].Plugin != "content.local" || recorded.Entries[1].Outcome != OutcomeInitialized {
+		t.Fatalf("unexpected trace %+v", recorded.Entries)
+	}
+
+	if d := recorded.Compare(traceRun(t, "/var/lib", nil)); len(d) != 0 {
+		t.Fatalf("expected identical runs, got %v", d)
+	}
+	d := recorded.Compare(traceRun(t, "/data", ErrSkipPlugin))
+	if len(d) != 2 || d[0].Reason != "config differs" || d[1].Plugin != "snapshotter.overlayfs" {
+		t.Fatalf("unexpected divergences %v", d)
+	}
+	if d[1].Reason != "outcome skipped (skip plugin), recorded initialized" {
+		t.Fatalf("unexpected outcome divergence %q", d[1].Reason)
+	}
+
+	var registry Registry
+	registry = registry.Register(&Registration{Type: "snapshotter", ID: "overlayfs"}).
+		Register(&Registration{Type: "content", ID: "local", Requires: []Type{"snapshotter"}}).
+		Register(&Registration{Type: "diff", ID: "walking"})
+	d = recorded.Replay(registry.GraphRefs(mockPluginFilter))
+	if len(d) != 2 || d[0].Plugin != "diff.walking" || d[0].Reason != "not in trace" || d[1].Reason != "initialized in a different order" {
+		t.Fatalf("unexpected replay divergences %v", d)
+	}
+}
@@ -0,0 +1,119 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// TypedRegistration is a registration whose init function returns an
+// instance of type T. All registration fields other than InitFn are set on
+// the embedded Registration.
+type TypedRegistration[T any] struct {
+	Registration
+	// InitFn is called to initialize the plugin, the InitFn of the
+	// embedded Registration is ignored
+	InitFn func(*InitContext) (T, error)
+}
+
+// RegisterTyped adds the typed registration to the registry, panicking in
+// the same way as Registry.Register if the registration is invalid.
+func RegisterTyped[T any](registry *Registry, reg TypedRegistration[T]) {
+	r := reg.Registration
+	if initFn := reg.InitFn; initFn != nil {
+		r.InitFn = func(ic *InitContext) (interface{}, error) {
+			return initFn(ic)
+		}
+	} else {
+		r.InitFn = nil
+	}
+	*registry = registry.Register(&r)
+}
+
+// ErrInstanceType is returned when a plugin instance does not have the type
+// expected by the caller
+var ErrInstanceType = errors.New("plugin: unexpected instance type")
+
+// InstanceTypeError is returned by GetSingleAs and GetByIDAs when the plugin
+// instance does not have the requested type
+type InstanceTypeError struct {
+	// Plugin is the URI of the plugin whose instance was requested
+	Plugin string
+	// Expected is the requested type
+	Expected string
+	// Actual is the type of the plugin instance
+	Actual string
+}
+
+func (e *InstanceTypeError) Error() string {
+	return fmt.Sprintf("%s is %s, not %s: %v", e.Plugin, e.Actual, e.Expected, ErrInstanceType)
+}
+
+// Unwrap returns ErrInstanceType
+func (e *InstanceTypeError) Unwrap() error {
+	return ErrInstanceType
+}
+
+// Get returns the single instance of the given type as T, it is equivalent
+// to GetSingleAs.
+func Get[T any](ic *InitContext, t Type) (T, error) {
+	return GetSingleAs[T](ic, t)
+}
+
+// GetSingleAs returns the single instance of the given type as T, returning
+// an InstanceTypeError if the instance is not a T. See
+// InitContext.GetSingle.
+func GetSingleAs[T any](ic *InitContext, t Type) (T, error) {
+	p, err := ic.getSingle(t)
+	if p == nil {
+		var zero T
+		return zero, err
+	}
+	return instanceAs[T](p)
+}
+
+// GetByIDAs returns the instance of the plugin with the given type and ID as
+// T, returning an InstanceTypeError if the instance is not a T. See
+// InitContext.GetByID.
+func GetByIDAs[T any](ic *InitContext, t Type, id string) (T, error) {
+	p, err := ic.getByID(t, id)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return instanceAs[T](p)
+}
+
+// instanceAs returns the instance of the plugin as T
+func instanceAs[T any](p *Plugin) (T, error) {
+	var zero T
+	i, err := p.Instance()
+	if err != nil {
+		return zero, err
+	}
+	v, ok := i.(T)
+	if !ok {
+		return zero, &InstanceTypeError{
+			Plugin:   p.Registration.URI(),
+			Expected: reflect.TypeOf((*T)(nil)).Elem().String(),
+			Actual:   fmt.Sprintf("%T", i),
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,80 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidType is used when a plugin type does not have the form
+// "<namespace>.<kind>.v<version>", such as "io.containerd.snapshotter.v1".
+var ErrInvalidType = errors.New("plugin: invalid type")
+
+// NewType returns the plugin type for the namespace, kind and version, such
+// as "io.containerd.snapshotter.v1" for "io.containerd", "snapshotter" and 1.
+func NewType(namespace, kind string, version int) (Type, error) {
+	t := Type(fmt.Sprintf("%s.%s.v%d", namespace, kind, version))
+	if !validName(kind, false) || !validName(namespace, true) || version < 1 {
+		return "", fmt.Errorf("%q: %w", t, ErrInvalidType)
+	}
+	return t, nil
+}
+
+// Parse splits the type into its namespace, kind and version, such as
+// "io.containerd", "snapshotter" and 1 for "io.containerd.snapshotter.v1".
+func (t Type) Parse() (namespace, kind string, version int, err error) {
+	s := string(t)
+	i := strings.LastIndexByte(s, '.')
+	if i < 0 || !strings.HasPrefix(s[i+1:], "v") {
+		return "", "", 0, fmt.Errorf("%q: missing version: %w", s, ErrInvalidType)
+	}
+	version, err = strconv.Atoi(s[i+2:])
+	if err != nil || version < 1 {
+		return "", "", 0, fmt.Errorf("%q: invalid version: %w", s, ErrInvalidType)
+	}
+	j := strings.LastIndexByte(s[:i], '.')
+	if j < 0 {
+		return "", "", 0, fmt.Errorf("%q: missing namespace: %w", s, ErrInvalidType)
+	}
+	namespace, kind = s[:j], s[j+1:i]
+	if !validName(kind, false) || !validName(namespace, true) {
+		return "", "", 0, fmt.Errorf("%q: %w", s, ErrInvalidType)
+	}
+	return namespace, kind, version, nil
+}
+
+// validName returns true if the name is made of lowercase letters, digits
+// and dashes, with dot separated segments when dotted is set.
+func validName(name string, dotted bool) bool {
+	if name == "" {
+		return false
+	}
+	for _, segment := range strings.Split(name, ".") {
+		if segment == "" || (!dotted && segment != name) {
+			return false
+		}
+		for _, c := range segment {
+			if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Validator checks a registration against host policy, such as naming
+// conventions or forbidden dependencies, returning an error to reject it.
+type Validator func(*Registration) error
+
+// Check runs the validators against every registration, returning the
+// rejections joined into a single error.
+func (registry Registry) Check(validators ...Validator) error {
+	var errs []error
+	for _, r := range registry {
+		if err := check(r, validators); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func check(r *Registration, validators []Validator) error {
+	for _, v := range validators {
+		if err := v(r); err != nil {
+			return fmt.Errorf("%s: %w", r.URI(), err)
+		}
+	}
+	return nil
+}
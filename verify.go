@@ -0,0 +1,94 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// VerifyFailure is a plugin which failed initialization or verification
+type VerifyFailure struct {
+	// Plugin is the URI of the failed plugin
+	Plugin string
+	// Critical is set when the plugin is marked critical
+	Critical bool
+	// Err is the initialization or verification error
+	Err error
+}
+
+// VerifyError is returned by Set.Verify when critical plugins failed. It
+// reports every failure found, critical or not.
+type VerifyError struct {
+	Failures []VerifyFailure
+}
+
+func (e *VerifyError) Error() string {
+	var b strings.Builder
+	b.WriteString(ErrCriticalPlugin.Error())
+	for _, f := range e.Failures {
+		if f.Critical {
+			fmt.Fprintf(&b, "\n  %s (critical): %v", f.Plugin, f.Err)
+		} else {
+			fmt.Fprintf(&b, "\n  %s: %v", f.Plugin, f.Err)
+		}
+	}
+	return b.String()
+}
+
+// Unwrap returns ErrCriticalPlugin
+func (e *VerifyError) Unwrap() error {
+	return ErrCriticalPlugin
+}
+
+// Verify runs the Verify hook of every active plugin in initialization order.
+// Plugins failing verification are marked failed. A VerifyError is returned
+// when a critical plugin failed to initialize or verify, in which case the
+// daemon should abort startup.
+func (ps *Set) Verify(ctx context.Context) error {
+	var (
+		failures []VerifyFailure
+		critical bool
+	)
+	for _, p := range ps.ordered {
+		r := &p.Registration
+		var err error
+		switch p.State() {
+		case StateActive:
+			if r.Verify == nil {
+				continue
+			}
+			instance, _ := p.Instance()
+			if err = r.Verify(ctx, instance); err != nil {
+				ps.MarkFailed(p, err)
+			}
+		case StateSkipped:
+			continue
+		default:
+			err = p.StateErr()
+		}
+		if err != nil {
+			failures = append(failures, VerifyFailure{Plugin: r.URI(), Critical: r.Critical, Err: err})
+			critical = critical || r.Critical
+		}
+	}
+	if !critical {
+		return nil
+	}
+	return &VerifyError{Failures: failures}
+}
@@ -0,0 +1,151 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrVersionMismatch is used when a dependency is present but its version
+// does not satisfy the constraint of the plugin requiring it.
+var ErrVersionMismatch = errors.New("plugin: version mismatch")
+
+// VersionError is returned when a dependency does not satisfy the version
+// constraint of a Requires or Optional entry.
+type VersionError struct {
+	// Plugin is the URI of the plugin declaring the constraint
+	Plugin string
+	// Constraint is the entry declaring the constraint
+	Constraint Type
+	// Dependency is the URI of the plugin not satisfying the constraint
+	Dependency string
+	// Version is the version of the dependency, empty if not set
+	Version string
+}
+
+func (e *VersionError) Error() string {
+	version := e.Version
+	if version == "" {
+		version = "no version"
+	}
+	return fmt.Sprintf("%s requires %q but %s has %s: %v", e.Plugin, e.Constraint, e.Dependency, version, ErrVersionMismatch)
+}
+
+// Unwrap returns ErrVersionMismatch
+func (e *VersionError) Unwrap() error {
+	return ErrVersionMismatch
+}
+
+// versionConstraint is the version constraint of a dependency such as
+// "io.containerd.snapshotter.v1 >= 1.2"
+type versionConstraint struct {
+	op      string
+	version []int
+}
+
+// constraint splits a dependency into the type or URI it matches and its
+// version constraint, if any.
+func (t Type) constraint() (Type, *versionConstraint, error) {
+	s := string(t)
+	if strings.IndexByte(s, ' ') < 0 {
+		if !t.validPattern() {
+			return t, nil, fmt.Errorf("%q: malformed pattern: %w", s, ErrInvalidRequires)
+		}
+		return t, nil, nil
+	}
+	fields := strings.Fields(s)
+	if len(fields) != 3 {
+		return t, nil, fmt.Errorf("%q: expected \"<type> <op> <version>\": %w", s, ErrInvalidRequires)
+	}
+	switch fields[1] {
+	case "=", "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return t, nil, fmt.Errorf("%q: unknown operator %q: %w", s, fields[1], ErrInvalidRequires)
+	}
+	v, err := parseVersion(fields[2])
+	if err != nil {
+		return t, nil, fmt.Errorf("%q: %v: %w", s, err, ErrInvalidRequires)
+	}
+	if !Type(fields[0]).validPattern() {
+		return t, nil, fmt.Errorf("%q: malformed pattern: %w", s, ErrInvalidRequires)
+	}
+	return Type(fields[0]), &versionConstraint{op: fields[1], version: v}, nil
+}
+
+// satisfiedBy returns true if the version satisfies the constraint,
+// registrations without a valid version never satisfy a constraint.
+func (c *versionConstraint) satisfiedBy(version string) bool {
+	v, err := parseVersion(version)
+	if err != nil {
+		return false
+	}
+	cmp := compareVersions(v, c.version)
+	switch c.op {
+	case "=", "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+// parseVersion parses the numeric components of a semantic version such as
+// "v1.2.3", pre-release and build metadata are ignored.
+func parseVersion(s string) ([]int, error) {
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	if s == "" {
+		return nil, errors.New("empty version")
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("invalid version %q", s)
+	}
+	v := make([]int, 3)
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version %q", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+func compareVersions(a, b []int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
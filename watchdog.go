@@ -0,0 +1,153 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultPingTimeout is the time a ping may take unless set with
+// WithPingTimeout
+const defaultPingTimeout = 10 * time.Second
+
+// Pinger is implemented by plugin instances which can report whether they
+// are still responsive, such as an external snapshotter process.
+type Pinger interface {
+	Ping(context.Context) error
+}
+
+// Watchdog periodically pings the plugins of a set implementing Pinger,
+// degrading and then failing plugins which repeatedly fail to respond.
+type Watchdog struct {
+	set          *Set
+	timeout      time.Duration
+	degradeAfter int
+	failAfter    int
+
+	// mu serializes checks and protects the failure counts
+	mu       sync.Mutex
+	failures map[*Plugin]int
+	degraded map[*Plugin]bool
+}
+
+// WatchdogOpt is an option for a Watchdog
+type WatchdogOpt func(*Watchdog)
+
+// WithPingTimeout limits the time each ping may take, a ping exceeding the
+// timeout counts as a failure. Defaults to 10 seconds.
+func WithPingTimeout(d time.Duration) WatchdogOpt {
+	return func(w *Watchdog) {
+		w.timeout = d
+	}
+}
+
+// WithPingFailures sets the number of consecutive failed pings after which
+// a plugin is degraded and failed. Defaults to 1 and 3.
+func WithPingFailures(degradeAfter, failAfter int) WatchdogOpt {
+	return func(w *Watchdog) {
+		w.degradeAfter = degradeAfter
+		w.failAfter = failAfter
+	}
+}
+
+// NewWatchdog returns a watchdog for the plugins in the set
+func (ps *Set) NewWatchdog(opts ...WatchdogOpt) *Watchdog {
+	w := &Watchdog{
+		set:          ps,
+		timeout:      defaultPingTimeout,
+		degradeAfter: 1,
+		failAfter:    3,
+		failures:     map[*Plugin]int{},
+		degraded:     map[*Plugin]bool{},
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Run checks the plugins at the given interval until the context is canceled
+func (w *Watchdog) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			w.Check(ctx)
+		}
+	}
+}
+
+// Check pings every active or degraded plugin implementing Pinger once. A
+// plugin reaching the failure thresholds is degraded or marked failed,
+// applying the set's cascade policy, and a plugin degraded by the watchdog
+// which responds again is restored to active. An event is emitted for each
+// transition. A ping not returning within the ping timeout is abandoned and
+// counts as a failure. Check may be called concurrently, checks are
+// serialized.
+func (w *Watchdog) Check(ctx context.Context) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, p := range w.set.ordered {
+		switch p.State() {
+		case StateActive, StateDegraded:
+		default:
+			continue
+		}
+		instance, _ := p.Instance()
+		pinger, ok := instance.(Pinger)
+		if !ok {
+			continue
+		}
+		if err := runWithTimeout(ctx, w.timeout, pinger.Ping); err != nil {
+			w.failures[p]++
+			w.failed(p, fmt.Errorf("ping failed %d times: %w", w.failures[p], err))
+			continue
+		}
+		w.failures[p] = 0
+		if w.degraded[p] {
+			delete(w.degraded, p)
+			w.set.setState(p, StateActive, nil)
+		}
+	}
+}
+
+func (w *Watchdog) failed(p *Plugin, err error) {
+	switch n := w.failures[p]; {
+	case n >= w.failAfter:
+		delete(w.failures, p)
+		delete(w.degraded, p)
+		w.set.MarkFailed(p, err)
+	case n >= w.degradeAfter && p.State() == StateActive:
+		w.degraded[p] = true
+		w.set.setState(p, StateDegraded, err)
+	}
+}
+
+// setState transitions a single plugin without cascading to its dependents
+func (ps *Set) setState(p *Plugin, s State, err error) {
+	ps.lifecycle.Lock()
+	p.transition(s, err)
+	ps.lifecycle.Unlock()
+	ps.publish()
+	ps.emit([]Event{{Plugin: p.Registration.URI(), State: s, Err: err}})
+}